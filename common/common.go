@@ -38,6 +38,39 @@ func TxHash(tx *types.Transaction) types.Hash {
 	return v
 }
 
+// TxSize returns the RLP encoded size of tx. tx.Size is never touched, as other modules may cache a different type in
+// it, the caller should cache the size by hash if needed.
+func TxSize(tx *types.Transaction) uint64 {
+	var c writeCounter
+	rlp.Encode(&c, tx)
+	return uint64(c)
+}
+
+// writeCounter counts the bytes written to it.
+type writeCounter uint64
+
+func (c *writeCounter) Write(b []byte) (int, error) {
+	*c += writeCounter(len(b))
+	return len(b), nil
+}
+
+// TxPrice returns the gas price of tx, nil price is treated as zero.
+func TxPrice(tx *types.Transaction) *big.Int {
+	if tx.Data.Price == nil {
+		return new(big.Int)
+	}
+	return tx.Data.Price
+}
+
+// TxCost returns the maximum cost of tx, which is amount + gasLimit * price.
+func TxCost(tx *types.Transaction) *big.Int {
+	cost := new(big.Int).Mul(TxPrice(tx), new(big.Int).SetUint64(tx.Data.GasLimit))
+	if tx.Data.Amount != nil {
+		cost.Add(cost, tx.Data.Amount)
+	}
+	return cost
+}
+
 func CopyBytes(b []byte) (copiedBytes []byte) {
 	if b == nil {
 		return nil
@@ -2,6 +2,7 @@ package common
 
 import (
 	"fmt"
+	"github.com/DSiSc/craft/rlp"
 	"github.com/DSiSc/craft/types"
 	"github.com/stretchr/testify/assert"
 	"math/big"
@@ -44,6 +45,28 @@ func TestCopyBytes(t *testing.T) {
 	assert.Equal(t, b, c)
 }
 
+func TestTxSize(t *testing.T) {
+	assert := assert.New(t)
+	b := types.Address{
+		0xb2, 0x6f, 0x2b, 0x34, 0x2a, 0xab, 0x24, 0xbc, 0xf6, 0x3e,
+		0xa2, 0x18, 0xc6, 0xa9, 0x27, 0x4d, 0x30, 0xab, 0x9a, 0x15,
+	}
+	tx := NewTransaction(0, b, big.NewInt(0), 0, big.NewInt(0), b[:10], b)
+	encoded, err := rlp.EncodeToBytes(tx)
+	assert.Nil(err)
+	assert.Equal(uint64(len(encoded)), TxSize(tx))
+
+	largeTx := NewTransaction(0, b, big.NewInt(0), 0, big.NewInt(0), make([]byte, 1024), b)
+	assert.True(TxSize(largeTx) > TxSize(tx))
+
+	// size cached as other type by other modules is left untouched
+	otherTx := NewTransaction(0, b, big.NewInt(0), 0, big.NewInt(0), b[:10], b)
+	otherTx.Size.Store(len(encoded))
+	assert.Equal(uint64(len(encoded)), TxSize(otherTx))
+	assert.Equal(len(encoded), otherTx.Size.Load())
+	assert.Nil(tx.Size.Load())
+}
+
 func TestTxHash(t *testing.T) {
 	assert := assert.New(t)
 	b := types.Address{
@@ -0,0 +1,159 @@
+package common
+
+import (
+	"errors"
+	"github.com/DSiSc/craft/types"
+	"github.com/DSiSc/crypto-suite/crypto"
+	"math/big"
+)
+
+var (
+	ErrInvalidSig     = errors.New("invalid transaction v, r, s values")
+	ErrInvalidChainId = errors.New("invalid chain id for signer")
+)
+
+// Signer encapsulates transaction signature handling.
+type Signer interface {
+	// Sender returns the sender address recovered from the signature of the transaction.
+	Sender(tx *types.Transaction) (types.Address, error)
+	// Hash returns the hash to be signed.
+	Hash(tx *types.Transaction) types.Hash
+	// Equal returns true if the given signer is the same as the receiver.
+	Equal(Signer) bool
+}
+
+// Sender returns the address derived from the signature (V, R, S) using secp256k1 elliptic curve and an error if
+// it failed deriving or upon an incorrect signature. The result is not cached in tx, as tx.From is shared with other
+// modules, callers should cache it by themselves if needed.
+func Sender(signer Signer, tx *types.Transaction) (types.Address, error) {
+	if signer == nil {
+		return types.Address{}, ErrInvalidSig
+	}
+	return signer.Sender(tx)
+}
+
+// EIP155Signer implements Signer using the EIP155 rules, the signatures without chain id protection are also
+// accepted.
+type EIP155Signer struct {
+	chainId, chainIdMul *big.Int
+}
+
+// NewEIP155Signer create an EIP155Signer instance for the specified chain.
+func NewEIP155Signer(chainId *big.Int) EIP155Signer {
+	if chainId == nil {
+		chainId = new(big.Int)
+	}
+	return EIP155Signer{
+		chainId:    chainId,
+		chainIdMul: new(big.Int).Mul(chainId, big.NewInt(2)),
+	}
+}
+
+// Equal returns true if s is an EIP155Signer of the same chain.
+func (self EIP155Signer) Equal(s Signer) bool {
+	eip155, ok := s.(EIP155Signer)
+	return ok && eip155.chainId.Cmp(self.chainId) == 0
+}
+
+// Sender returns the sender address recovered from the signature of tx.
+func (self EIP155Signer) Sender(tx *types.Transaction) (types.Address, error) {
+	if tx.Data.V == nil || tx.Data.R == nil || tx.Data.S == nil {
+		return types.Address{}, ErrInvalidSig
+	}
+	if !isProtectedV(tx.Data.V) {
+		return recoverPlain(unprotectedHash(tx), tx.Data.R, tx.Data.S, tx.Data.V)
+	}
+	if deriveChainId(tx.Data.V).Cmp(self.chainId) != 0 {
+		return types.Address{}, ErrInvalidChainId
+	}
+	V := new(big.Int).Sub(tx.Data.V, self.chainIdMul)
+	V.Sub(V, big.NewInt(8))
+	return recoverPlain(self.Hash(tx), tx.Data.R, tx.Data.S, V)
+}
+
+// Hash returns the hash to be signed by the sender, which includes the chain id.
+func (self EIP155Signer) Hash(tx *types.Transaction) types.Hash {
+	return rlpHash([]interface{}{
+		tx.Data.AccountNonce,
+		tx.Data.Price,
+		tx.Data.GasLimit,
+		tx.Data.Recipient,
+		tx.Data.Amount,
+		tx.Data.Payload,
+		self.chainId, uint(0), uint(0),
+	})
+}
+
+// SignatureValues returns the V, R, S values of the signature, sig must be in the [R || S || V] format where V is
+// 0 or 1.
+func (self EIP155Signer) SignatureValues(sig []byte) (R, S, V *big.Int, err error) {
+	if len(sig) != 65 {
+		return nil, nil, nil, ErrInvalidSig
+	}
+	R = new(big.Int).SetBytes(sig[:32])
+	S = new(big.Int).SetBytes(sig[32:64])
+	V = new(big.Int).SetBytes([]byte{sig[64] + 35})
+	V.Add(V, self.chainIdMul)
+	return R, S, V, nil
+}
+
+// hash to be signed without chain id protection.
+func unprotectedHash(tx *types.Transaction) types.Hash {
+	return rlpHash([]interface{}{
+		tx.Data.AccountNonce,
+		tx.Data.Price,
+		tx.Data.GasLimit,
+		tx.Data.Recipient,
+		tx.Data.Amount,
+		tx.Data.Payload,
+	})
+}
+
+// signatures with V 27 or 28 are not protected by chain id. The raw 0 and 1 are not protected either, but they are
+// rejected by recoverPlain, as only 27 and 28 are accepted for the unprotected signatures.
+func isProtectedV(V *big.Int) bool {
+	if V.BitLen() <= 8 {
+		v := V.Uint64()
+		return v != 27 && v != 28 && v != 1 && v != 0
+	}
+	return true
+}
+
+// deriveChainId derives the chain id from the given v parameter.
+func deriveChainId(v *big.Int) *big.Int {
+	if v.BitLen() <= 64 {
+		v := v.Uint64()
+		if v == 27 || v == 28 {
+			return new(big.Int)
+		}
+		return new(big.Int).SetUint64((v - 35) / 2)
+	}
+	v = new(big.Int).Sub(v, big.NewInt(35))
+	return v.Div(v, big.NewInt(2))
+}
+
+// recover the sender address from signature values.
+func recoverPlain(sighash types.Hash, R, S, Vb *big.Int) (types.Address, error) {
+	if Vb.BitLen() > 8 || Vb.Uint64() < 27 {
+		return types.Address{}, ErrInvalidSig
+	}
+	V := byte(Vb.Uint64() - 27)
+	if !crypto.ValidateSignatureValues(V, R, S, true) {
+		return types.Address{}, ErrInvalidSig
+	}
+	// encode the signature in uncompressed format
+	r, s := R.Bytes(), S.Bytes()
+	sig := make([]byte, 65)
+	copy(sig[32-len(r):32], r)
+	copy(sig[64-len(s):64], s)
+	sig[64] = V
+	// recover the public key from the signature
+	pub, err := crypto.Ecrecover(sighash[:], sig)
+	if err != nil {
+		return types.Address{}, err
+	}
+	if len(pub) == 0 || pub[0] != 4 {
+		return types.Address{}, errors.New("invalid public key")
+	}
+	return BytesToAddress(crypto.Keccak256(pub[1:])[12:]), nil
+}
@@ -0,0 +1,57 @@
+package common
+
+import (
+	"github.com/DSiSc/craft/types"
+	"github.com/DSiSc/crypto-suite/crypto"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"testing"
+)
+
+// sign tx with the private key, and returns the key's address.
+func mockSignedTx(t *testing.T, signer EIP155Signer, nonce uint64) (*types.Transaction, types.Address) {
+	key, err := crypto.GenerateKey()
+	assert.Nil(t, err)
+	from := BytesToAddress(crypto.Keccak256(crypto.FromECDSAPub(&key.PublicKey)[1:])[12:])
+	tx := NewTransaction(nonce, from, big.NewInt(1), 21000, big.NewInt(1), nil, from)
+	hash := signer.Hash(tx)
+	sig, err := crypto.Sign(hash[:], key)
+	assert.Nil(t, err)
+	tx.Data.R, tx.Data.S, tx.Data.V, err = signer.SignatureValues(sig)
+	assert.Nil(t, err)
+	return tx, from
+}
+
+func TestSender(t *testing.T) {
+	assert := assert.New(t)
+	signer := NewEIP155Signer(big.NewInt(1))
+	tx, from := mockSignedTx(t, signer, 0)
+
+	addr, err := Sender(signer, tx)
+	assert.Nil(err)
+	assert.Equal(from, addr)
+
+	// tx.From shared with other modules is untouched
+	assert.Nil(tx.From.Load())
+
+	// raw V of 0 or 1 is not accepted
+	tx.Data.V = new(big.Int).Sub(tx.Data.V, big.NewInt(37))
+	_, err = Sender(signer, tx)
+	assert.Equal(ErrInvalidSig, err)
+
+	// signature of other chain
+	tx, _ = mockSignedTx(t, signer, 0)
+	_, err = Sender(NewEIP155Signer(big.NewInt(2)), tx)
+	assert.Equal(ErrInvalidChainId, err)
+
+	// tampered tx
+	tx, from = mockSignedTx(t, signer, 0)
+	tx.Data.AccountNonce = 1
+	addr, err = Sender(signer, tx)
+	assert.True(err != nil || addr != from)
+
+	// unsigned tx
+	tx = NewTransaction(0, from, big.NewInt(1), 21000, big.NewInt(1), nil, from)
+	_, err = Sender(signer, tx)
+	assert.Equal(ErrInvalidSig, err)
+}
@@ -0,0 +1,75 @@
+package txpool
+
+import (
+	"errors"
+	"fmt"
+	"github.com/DSiSc/craft/types"
+)
+
+var (
+	// ErrNonceTooLow is returned if the nonce of transaction is lower than the one in chain.
+	ErrNonceTooLow = errors.New("nonce too low")
+
+	// ErrAlreadyKnown is returned if the transaction is already contained within the pool.
+	ErrAlreadyKnown = errors.New("already known")
+
+	// ErrPoolFull is returned if the pool is full and the transaction can't be added.
+	ErrPoolFull = errors.New("txpool is full")
+
+	// ErrUnderpriced is returned if the pool is full and the transaction is not pricier than the cheapest one in
+	// pool.
+	ErrUnderpriced = errors.New("transaction underpriced")
+
+	// ErrReplaceUnderpriced is returned if a transaction is attempted to be replaced with a different one without
+	// the required price bump.
+	ErrReplaceUnderpriced = errors.New("replacement transaction underpriced")
+
+	// ErrAccountLimitExceeded is returned if the account's queue is full and the transaction has the highest nonce.
+	ErrAccountLimitExceeded = errors.New("account limit exceeded")
+
+	// ErrInvalidSender is returned if the sender recovered from the transaction signature is not the From address.
+	ErrInvalidSender = errors.New("invalid sender")
+
+	// ErrOversizedData is returned if the RLP encoded size of transaction exceeds the limit.
+	ErrOversizedData = errors.New("oversized data")
+
+	// ErrOversizedPayload is returned if the payload of transaction exceeds the limit.
+	ErrOversizedPayload = errors.New("oversized payload")
+
+	// ErrGasLimit is returned if the gas limit of transaction exceeds the block gas limit.
+	ErrGasLimit = errors.New("exceeds block gas limit")
+
+	// ErrNegativeValue is returned if the amount of transaction is negative.
+	ErrNegativeValue = errors.New("negative value")
+
+	// ErrIntrinsicGas is returned if the gas limit of transaction is lower than the intrinsic gas.
+	ErrIntrinsicGas = errors.New("intrinsic gas too low")
+
+	// ErrInsufficientFunds is returned if the balance of sender can't afford the cost of transaction.
+	ErrInsufficientFunds = errors.New("insufficient funds for gas * price + value")
+
+	// ErrPoolClosed is returned if the pool has been stopped.
+	ErrPoolClosed = errors.New("txpool is closed")
+)
+
+// TxError is the error returned by txpool when a transaction is rejected, it carries the hash of the transaction
+// and wraps the reason, which can be inspected by errors.Is and errors.As.
+type TxError struct {
+	Hash types.Hash
+	Err  error
+}
+
+// Error returns the description of the error.
+func (e *TxError) Error() string {
+	return fmt.Sprintf("tx %x: %v", e.Hash, e.Err)
+}
+
+// Unwrap returns the reason why the transaction is rejected.
+func (e *TxError) Unwrap() error {
+	return e.Err
+}
+
+// newTxError wraps err with the transaction hash.
+func newTxError(hash types.Hash, err error) error {
+	return &TxError{Hash: hash, Err: err}
+}
@@ -0,0 +1,166 @@
+package txpool
+
+import (
+	"github.com/DSiSc/craft/log"
+	"github.com/DSiSc/craft/types"
+	"sync"
+)
+
+// TxsEventKind is the kind of the lifecycle event of transactions in txpool.
+type TxsEventKind int
+
+const (
+	TxsQueued   TxsEventKind = iota // Transactions are added to queue, as they are not executable yet
+	TxsPromoted                     // Transactions are added or promoted to pending, as they are executable
+	TxsReplaced                     // Transactions are replaced by the ones with same nonce and higher price
+	TxsEvicted                      // Transactions are evicted to make room for other ones
+	TxsExpired                      // Transactions are removed as they exceed the max cache time
+	TxsDropped                      // Transactions are dropped on block commit, as they are included or invalid
+)
+
+// NewTxsEvent is posted to the subscribers when the lifecycle of transactions changes.
+type NewTxsEvent struct {
+	Kind TxsEventKind
+	Txs  []*types.Transaction
+}
+
+// Subscription represents a subscription of txpool events.
+type Subscription interface {
+	// Unsubscribe stops delivering events to the subscribed channel, it's safe to call Unsubscribe multiple times.
+	Unsubscribe()
+}
+
+// maxPendingEvents is the max number of events waiting to be delivered to a subscriber, the events exceeding it are
+// dropped, so a slow subscriber can't hold unbounded memory.
+const maxPendingEvents = 1024
+
+// txsFeed delivers the transaction events to the subscribed channels, each subscription delivers the events in its
+// own goroutine, so a slow subscriber never blocks txpool or the other subscribers.
+type txsFeed struct {
+	mu   sync.Mutex
+	subs map[*txsSubscription]struct{}
+	quit <-chan struct{}
+}
+
+// txsSubscription is the subscription of a channel to txsFeed.
+type txsSubscription struct {
+	feed    *txsFeed
+	ch      chan<- NewTxsEvent
+	mu      sync.Mutex
+	pending []NewTxsEvent
+	wake    chan struct{}
+	quit    chan struct{}
+	once    sync.Once
+}
+
+// newTxsFeed creates a feed without subscribers, the delivery of events is stopped once quit is closed.
+func newTxsFeed(quit <-chan struct{}) *txsFeed {
+	return &txsFeed{
+		subs: make(map[*txsSubscription]struct{}),
+		quit: quit,
+	}
+}
+
+// subscribe adds ch to the feed, the events are delivered to ch until the subscription is unsubscribed.
+func (feed *txsFeed) subscribe(ch chan<- NewTxsEvent) Subscription {
+	sub := &txsSubscription{
+		feed: feed,
+		ch:   ch,
+		wake: make(chan struct{}, 1),
+		quit: make(chan struct{}),
+	}
+	feed.mu.Lock()
+	defer feed.mu.Unlock()
+	feed.subs[sub] = struct{}{}
+	go sub.loop()
+	return sub
+}
+
+// send queues events to all subscribers without blocking, the events are delivered to each subscriber in order.
+func (feed *txsFeed) send(events []NewTxsEvent) {
+	if len(events) == 0 {
+		return
+	}
+	feed.mu.Lock()
+	subs := make([]*txsSubscription, 0, len(feed.subs))
+	for sub := range feed.subs {
+		subs = append(subs, sub)
+	}
+	feed.mu.Unlock()
+	for _, sub := range subs {
+		sub.enqueue(events)
+	}
+}
+
+// enqueue appends events to the pending events of the subscription, and wakes up the delivery.
+func (sub *txsSubscription) enqueue(events []NewTxsEvent) {
+	sub.mu.Lock()
+	dropped := 0
+	for _, event := range events {
+		if len(sub.pending) >= maxPendingEvents {
+			dropped++
+			continue
+		}
+		sub.pending = append(sub.pending, event)
+	}
+	sub.mu.Unlock()
+	if dropped > 0 {
+		log.Warn("Dropped %d txs events, as the subscriber is too slow.", dropped)
+	}
+	select {
+	case sub.wake <- struct{}{}:
+	default:
+	}
+}
+
+// loop delivers the pending events to the subscribed channel until unsubscribed or the feed is stopped.
+func (sub *txsSubscription) loop() {
+	for {
+		select {
+		case <-sub.wake:
+		case <-sub.quit:
+			return
+		case <-sub.feed.quit:
+			return
+		}
+		sub.mu.Lock()
+		events := sub.pending
+		sub.pending = nil
+		sub.mu.Unlock()
+		for _, event := range events {
+			// stop delivering as soon as possible, even if the channel is ready to receive
+			if sub.stopped() {
+				return
+			}
+			select {
+			case sub.ch <- event:
+			case <-sub.quit:
+				return
+			case <-sub.feed.quit:
+				return
+			}
+		}
+	}
+}
+
+// stopped checks whether the subscription is unsubscribed or the feed is stopped.
+func (sub *txsSubscription) stopped() bool {
+	select {
+	case <-sub.quit:
+		return true
+	case <-sub.feed.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// Unsubscribe removes the subscription from the feed.
+func (sub *txsSubscription) Unsubscribe() {
+	sub.once.Do(func() {
+		sub.feed.mu.Lock()
+		delete(sub.feed.subs, sub)
+		sub.feed.mu.Unlock()
+		close(sub.quit)
+	})
+}
@@ -0,0 +1,168 @@
+package txpool
+
+import (
+	"context"
+	"github.com/DSiSc/craft/types"
+	"github.com/DSiSc/monkey"
+	"github.com/DSiSc/txpool/common"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTxsFeed(t *testing.T) {
+	assert := assert.New(t)
+	quit := make(chan struct{})
+	feed := newTxsFeed(quit)
+	txs := mock_transactions(2)
+	ch1 := make(chan NewTxsEvent, 2)
+	ch2 := make(chan NewTxsEvent)
+	sub1 := feed.subscribe(ch1)
+	sub2 := feed.subscribe(ch2)
+
+	// sending is not blocked by the subscriber which doesn't receive
+	done := make(chan struct{})
+	go func() {
+		feed.send([]NewTxsEvent{{Kind: TxsQueued, Txs: txs[:1]}, {Kind: TxsPromoted, Txs: txs[1:]}})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		assert.Fail("sending is blocked by the slow subscriber")
+	}
+	assert.Equal(NewTxsEvent{Kind: TxsQueued, Txs: txs[:1]}, <-ch1)
+	assert.Equal(NewTxsEvent{Kind: TxsPromoted, Txs: txs[1:]}, <-ch1)
+	assert.Equal(NewTxsEvent{Kind: TxsQueued, Txs: txs[:1]}, <-ch2)
+	sub2.Unsubscribe()
+	sub2.Unsubscribe()
+
+	sub1.Unsubscribe()
+	feed.send([]NewTxsEvent{{Kind: TxsQueued, Txs: txs}})
+	assert.Equal(0, len(ch1))
+
+	// the events exceeding the limit are dropped for the slow subscriber
+	sub := &txsSubscription{feed: feed, wake: make(chan struct{}, 1)}
+	events := make([]NewTxsEvent, maxPendingEvents+1)
+	sub.enqueue(events)
+	sub.enqueue(events)
+	assert.Equal(maxPendingEvents, len(sub.pending))
+
+	// nothing is delivered once the feed is stopped
+	ch3 := make(chan NewTxsEvent, 1)
+	feed.subscribe(ch3)
+	close(quit)
+	feed.send([]NewTxsEvent{{Kind: TxsQueued, Txs: txs}})
+	select {
+	case <-ch3:
+		assert.Fail("event is delivered after the feed is stopped")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestTxPool_SubscribeNewTxs(t *testing.T) {
+	defer monkey.UnpatchAll()
+	newMockChain()
+	assert := assert.New(t)
+	var mockTxPoolConfig = TxPoolConfig{
+		GlobalSlots:    2,
+		MaxTrsPerBlock: 10,
+	}
+	txpool := NewTxPool(mockTxPoolConfig, NewMockEvent())
+	ch := make(chan NewTxsEvent, 10)
+	sub := txpool.SubscribeNewTxs(ch)
+	defer sub.Unsubscribe()
+	sameFromTxs := mock_samefrom_transactions(5)
+	from := *sameFromTxs[0].Data.From
+
+	// future tx is queued, and promoted along with the preceding one
+	assert.Nil(txpool.AddTx(sameFromTxs[1]))
+	assert.Equal(NewTxsEvent{Kind: TxsQueued, Txs: sameFromTxs[1:2]}, <-ch)
+	assert.Nil(txpool.AddTx(sameFromTxs[0]))
+	assert.Equal(NewTxsEvent{Kind: TxsPromoted, Txs: sameFromTxs[:2]}, <-ch)
+
+	// replace tx with higher price
+	replacement := common.NewTransaction(0, from, big.NewInt(0), 0, big.NewInt(10), nil, from)
+	common.TxHash(replacement)
+	assert.Nil(txpool.AddTx(replacement))
+	assert.Equal(NewTxsEvent{Kind: TxsReplaced, Txs: sameFromTxs[:1]}, <-ch)
+	assert.Equal(NewTxsEvent{Kind: TxsPromoted, Txs: []*types.Transaction{replacement}}, <-ch)
+
+	// the cheapest tx is evicted by the pricier one
+	pricier := mock_transactions(1)[0]
+	pricier.Data.Price = big.NewInt(100)
+	assert.Nil(txpool.AddTx(pricier))
+	assert.Equal(NewTxsEvent{Kind: TxsEvicted, Txs: sameFromTxs[1:2]}, <-ch)
+	assert.Equal(NewTxsEvent{Kind: TxsPromoted, Txs: []*types.Transaction{pricier}}, <-ch)
+
+	// txs are dropped on block commit
+	txpool.DelTxs([]*types.Transaction{replacement})
+	assert.Equal(NewTxsEvent{Kind: TxsDropped, Txs: []*types.Transaction{replacement}}, <-ch)
+	assert.Equal(0, len(ch))
+}
+
+func TestTxPool_StopWithSlowSubscriber(t *testing.T) {
+	defer monkey.UnpatchAll()
+	newMockChain()
+	assert := assert.New(t)
+	txpool := NewTxPool(DefaultTxPoolConfig, NewMockEvent())
+	assert.Nil(txpool.Start(context.Background()))
+	ch := make(chan NewTxsEvent)
+	txpool.SubscribeNewTxs(ch)
+
+	// neither adding txs nor stopping txpool is blocked by the subscriber which doesn't receive
+	done := make(chan struct{})
+	go func() {
+		for _, tx := range mock_transactions(10) {
+			assert.Nil(txpool.AddTx(tx))
+		}
+		txpool.Reset(nil, nil)
+		txpool.Stop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		assert.Fail("txpool is blocked by the slow subscriber")
+	}
+}
+
+func TestTxPool_EventsOrder(t *testing.T) {
+	defer monkey.UnpatchAll()
+	newMockChain()
+	assert := assert.New(t)
+	txpool := NewTxPool(DefaultTxPoolConfig, NewMockEvent())
+	ch := make(chan NewTxsEvent, maxPendingEvents)
+	sub := txpool.SubscribeNewTxs(ch)
+	defer sub.Unsubscribe()
+
+	// the events of each tx are delivered in order, even if the events are flushed concurrently
+	accountsTxs := mock_accounts_transactions(50, 1)
+	var wg sync.WaitGroup
+	for _, txs := range accountsTxs {
+		wg.Add(1)
+		go func(tx *types.Transaction) {
+			defer wg.Done()
+			assert.Nil(txpool.AddTx(tx))
+			txpool.DelTxs([]*types.Transaction{tx})
+		}(txs[0])
+	}
+	wg.Wait()
+	kinds := make(map[*types.Transaction][]TxsEventKind)
+	for received := 0; received < 2*len(accountsTxs); {
+		select {
+		case event := <-ch:
+			for _, tx := range event.Txs {
+				kinds[tx] = append(kinds[tx], event.Kind)
+			}
+			received += len(event.Txs)
+		case <-time.After(time.Second):
+			assert.FailNow("events are not delivered")
+		}
+	}
+	for _, txs := range accountsTxs {
+		assert.Equal([]TxsEventKind{TxsPromoted, TxsDropped}, kinds[txs[0]])
+	}
+}
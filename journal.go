@@ -0,0 +1,122 @@
+package txpool
+
+import (
+	"errors"
+	"github.com/DSiSc/craft/log"
+	"github.com/DSiSc/craft/rlp"
+	"github.com/DSiSc/craft/types"
+	"io"
+	"os"
+	"sync"
+)
+
+// errNoActiveJournal is returned if a transaction is attempted to be inserted into the journal, but no such file is
+// currently open.
+var errNoActiveJournal = errors.New("no active journal")
+
+// txJournal is a rotating log of transactions with the aim of storing locally created transactions to allow
+// non-executed ones to survive node restarts.
+type txJournal struct {
+	path   string         // Filesystem path to store the transactions at
+	writer io.WriteCloser // Output stream to write new transactions into
+	mu     sync.Mutex     // Lock of writer, as transactions of different accounts are inserted in parallel
+}
+
+// newTxJournal creates a new transaction journal.
+func newTxJournal(path string) *txJournal {
+	return &txJournal{
+		path: path,
+	}
+}
+
+// load parses a transaction journal dump from disk, loading its contents into the pool by the add function.
+func (journal *txJournal) load(add func(*types.Transaction) error) error {
+	// Skip the parsing if the journal file doesn't exist at all
+	if _, err := os.Stat(journal.path); os.IsNotExist(err) {
+		return nil
+	}
+	input, err := os.Open(journal.path)
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+
+	stream := rlp.NewStream(input, 0)
+	total, dropped := 0, 0
+	var failure error
+	for {
+		tx := new(types.Transaction)
+		if err = stream.Decode(tx); err != nil {
+			if err != io.EOF {
+				failure = err
+			}
+			break
+		}
+		total++
+		if err = add(tx); err != nil {
+			log.Debug("Failed to add journaled transaction, as: %v", err)
+			dropped++
+		}
+	}
+	log.Info("Loaded transaction journal, transactions: %d, dropped: %d", total, dropped)
+	return failure
+}
+
+// insert adds the specified transaction to the journal.
+func (journal *txJournal) insert(tx *types.Transaction) error {
+	journal.mu.Lock()
+	defer journal.mu.Unlock()
+	if journal.writer == nil {
+		return errNoActiveJournal
+	}
+	return rlp.Encode(journal.writer, tx)
+}
+
+// rotate regenerates the transaction journal based on the current contents of the pool.
+func (journal *txJournal) rotate(all []*types.Transaction) error {
+	journal.mu.Lock()
+	defer journal.mu.Unlock()
+	// Close the current journal (if any is open)
+	if journal.writer != nil {
+		if err := journal.writer.Close(); err != nil {
+			return err
+		}
+		journal.writer = nil
+	}
+	// Generate a new journal with the contents of the current pool
+	replacement, err := os.OpenFile(journal.path+".new", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	for _, tx := range all {
+		if err = rlp.Encode(replacement, tx); err != nil {
+			replacement.Close()
+			return err
+		}
+	}
+	replacement.Close()
+
+	// Replace the live journal with the newly generated one
+	if err = os.Rename(journal.path+".new", journal.path); err != nil {
+		return err
+	}
+	sink, err := os.OpenFile(journal.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	journal.writer = sink
+	log.Info("Regenerated transaction journal, transactions: %d", len(all))
+	return nil
+}
+
+// close flushes the transaction journal contents to disk and closes the file.
+func (journal *txJournal) close() error {
+	journal.mu.Lock()
+	defer journal.mu.Unlock()
+	var err error
+	if journal.writer != nil {
+		err = journal.writer.Close()
+		journal.writer = nil
+	}
+	return err
+}
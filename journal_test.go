@@ -0,0 +1,100 @@
+package txpool
+
+import (
+	"github.com/DSiSc/craft/types"
+	"github.com/DSiSc/monkey"
+	"github.com/DSiSc/txpool/common"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Test journal insert, rotate and load
+func TestTxJournal(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "txpool")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	journal := newTxJournal(filepath.Join(dir, "transactions.rlp"))
+	txList := mock_transactions(3)
+	assert.Equal(errNoActiveJournal, journal.insert(txList[0]))
+
+	// nothing to load if the journal doesn't exist
+	var loaded []*types.Transaction
+	add := func(tx *types.Transaction) error {
+		loaded = append(loaded, tx)
+		return nil
+	}
+	assert.Nil(journal.load(add))
+	assert.Equal(0, len(loaded))
+
+	assert.Nil(journal.rotate(txList[:1]))
+	assert.Nil(journal.insert(txList[1]))
+	assert.Nil(journal.insert(txList[2]))
+	assert.Nil(journal.close())
+	assert.Nil(journal.load(add))
+	assert.Equal(3, len(loaded))
+	for i, tx := range loaded {
+		assert.Equal(common.TxHash(txList[i]), common.TxHash(tx))
+	}
+
+	// rotate drops the transactions not in pool any more
+	loaded = nil
+	assert.Nil(journal.rotate(txList[2:]))
+	assert.Nil(journal.close())
+	assert.Nil(journal.load(add))
+	assert.Equal(1, len(loaded))
+	assert.Equal(common.TxHash(txList[2]), common.TxHash(loaded[0]))
+}
+
+// Test local txs in pool survive restart by the journal
+func TestTxPool_Journal(t *testing.T) {
+	defer monkey.UnpatchAll()
+	chain := newMockChain()
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "txpool")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	config := DefaultTxPoolConfig
+	config.Journal = filepath.Join(dir, "transactions.rlp")
+	txpool := NewTxPool(config, NewMockEvent())
+	instance := txpool.(*TxPool)
+	txList := mock_samefrom_transactions(3)
+	for _, tx := range txList {
+		assert.Nil(txpool.AddLocalTx(tx))
+	}
+	remoteTx := mock_transactions(1)[0]
+	assert.Nil(txpool.AddRemoteTx(remoteTx))
+	assert.Nil(txpool.Close())
+
+	// restart pool, all the local txs are recovered, while the remote one is not journaled
+	txpool = NewTxPool(config, NewMockEvent())
+	instance = txpool.(*TxPool)
+	assert.Equal(3, instance.pending.Len())
+	for _, tx := range txList {
+		assert.NotNil(instance.pending.GetTx(common.TxHash(tx)))
+	}
+	assert.Nil(instance.pending.GetTx(common.TxHash(remoteTx)))
+	assert.True(instance.locals.Contains(*txList[0].Data.From))
+	assert.Nil(txpool.Close())
+
+	// restart pool after the first tx is packaged, the stale tx is dropped by validation
+	chain.setNonce(1)
+	txpool = NewTxPool(config, NewMockEvent())
+	instance = txpool.(*TxPool)
+	assert.Equal(2, instance.pending.Len())
+	assert.Nil(instance.pending.GetTx(common.TxHash(txList[0])))
+	assert.Nil(txpool.Close())
+
+	// the journal has been compacted to the txs in pool
+	var loaded []*types.Transaction
+	assert.Nil(instance.journal.load(func(tx *types.Transaction) error {
+		loaded = append(loaded, tx)
+		return nil
+	}))
+	assert.Equal(2, len(loaded))
+}
@@ -0,0 +1,65 @@
+package txpool
+
+import (
+	"github.com/DSiSc/craft/types"
+	"github.com/DSiSc/txpool/tools"
+)
+
+// PendingSnapshot is an immutable view of the executable pending transactions of txpool at a moment, which is
+// never changed by the later modification of txpool. It's safe to share a snapshot between goroutines, as each
+// iterator of the snapshot is independent.
+type PendingSnapshot struct {
+	txs     map[types.Address][]*tools.TimedTransaction // executable txs of each account, sorted by nonce
+	count   int                                         // number of txs in snapshot
+	version uint64                                      // version of pending when the snapshot is taken
+	head    uint64                                      // chain head when the snapshot is taken
+}
+
+// newPendingSnapshot creates a snapshot of the executable txs, txs must not be modified any more.
+func newPendingSnapshot(txs map[types.Address][]*tools.TimedTransaction, version, head uint64) *PendingSnapshot {
+	count := 0
+	for _, accountTxs := range txs {
+		count += len(accountTxs)
+	}
+	return &PendingSnapshot{
+		txs:     txs,
+		count:   count,
+		version: version,
+		head:    head,
+	}
+}
+
+// Len returns the number of transactions in snapshot.
+func (snapshot *PendingSnapshot) Len() int {
+	return snapshot.count
+}
+
+// Accounts returns the accounts having transactions in snapshot.
+func (snapshot *PendingSnapshot) Accounts() []types.Address {
+	accounts := make([]types.Address, 0, len(snapshot.txs))
+	for address := range snapshot.txs {
+		accounts = append(accounts, address)
+	}
+	return accounts
+}
+
+// Txs returns the transactions of account in snapshot sorted by nonce.
+func (snapshot *PendingSnapshot) Txs(address types.Address) []*types.Transaction {
+	accountTxs := snapshot.txs[address]
+	txs := make([]*types.Transaction, 0, len(accountTxs))
+	for _, timedTx := range accountTxs {
+		txs = append(txs, timedTx.Tx)
+	}
+	return txs
+}
+
+// Iterator returns a new iterator of the transactions in snapshot, the transactions are iterated by price while
+// keeping the nonce order of each account. Pop of the iterator skips the remaining transactions of the current
+// account, which is used when a transaction fails to be executed.
+func (snapshot *PendingSnapshot) Iterator() *tools.TxsByPriceAndNonce {
+	txs := make(map[types.Address][]*tools.TimedTransaction, len(snapshot.txs))
+	for address, accountTxs := range snapshot.txs {
+		txs[address] = accountTxs
+	}
+	return tools.NewTxsByPriceAndNonce(txs)
+}
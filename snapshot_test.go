@@ -0,0 +1,60 @@
+package txpool
+
+import (
+	"github.com/DSiSc/monkey"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestTxPool_Snapshot(t *testing.T) {
+	defer monkey.UnpatchAll()
+	chain := newMockChain()
+	assert := assert.New(t)
+	txpool := NewTxPool(DefaultTxPoolConfig, NewMockEvent())
+	accountTxs := mock_accounts_transactions(2, 4)
+	for _, txs := range accountTxs {
+		for _, tx := range txs[:3] {
+			assert.Nil(txpool.AddTx(tx))
+		}
+	}
+	cheap, pricy := *accountTxs[0][0].Data.From, *accountTxs[1][0].Data.From
+
+	// snapshot is shared until pending changes
+	snapshot := txpool.Snapshot()
+	assert.Equal(6, snapshot.Len())
+	assert.Equal(2, len(snapshot.Accounts()))
+	assert.Equal(accountTxs[0][:3], snapshot.Txs(cheap))
+	assert.True(snapshot == txpool.Snapshot())
+
+	// snapshot is not affected by the later modification of txpool
+	assert.Nil(txpool.AddTx(accountTxs[0][3]))
+	chain.commit(accountTxs[1][:1])
+	txpool.DelTxs(accountTxs[1][:1])
+	assert.Equal(6, snapshot.Len())
+	assert.Equal(accountTxs[1][:3], snapshot.Txs(pricy))
+	latest := txpool.Snapshot()
+	assert.True(snapshot != latest)
+	assert.Equal(6, latest.Len())
+	assert.Equal(accountTxs[1][1:3], latest.Txs(pricy))
+
+	// iterator pops the remaining txs of an account, without affecting the other iterators
+	iterator := snapshot.Iterator()
+	assert.Equal(accountTxs[1][0], iterator.Peek())
+	iterator.Shift()
+	assert.Equal(accountTxs[1][1], iterator.Peek())
+	iterator.Pop()
+	for _, tx := range accountTxs[0][:3] {
+		assert.Equal(tx, iterator.Peek())
+		iterator.Shift()
+	}
+	assert.Nil(iterator.Peek())
+	assert.Equal(accountTxs[1][0], snapshot.Iterator().Peek())
+
+	// snapshot is renewed once the chain head changes
+	chain.commit(accountTxs[0][:1])
+	txpool.(*TxPool).updateChainInstance(nil)
+	snapshot = txpool.Snapshot()
+	assert.True(snapshot != latest)
+	assert.Equal(accountTxs[0][1:], snapshot.Txs(cheap))
+	assert.True(snapshot == txpool.Snapshot())
+}
@@ -0,0 +1,46 @@
+package txpool
+
+import (
+	"github.com/DSiSc/craft/types"
+	"time"
+)
+
+// TxStatusKind is the lifecycle status of a transaction.
+type TxStatusKind int
+
+const (
+	TxStatusUnknown  TxStatusKind = iota // Transaction is never seen, or removed too long ago
+	TxStatusPending                      // Transaction is executable and waiting in pending
+	TxStatusQueued                       // Transaction is not executable yet and waiting in queue
+	TxStatusIncluded                     // Transaction is removed as it's included in block
+	TxStatusReplaced                     // Transaction is replaced by another one with same nonce and higher price
+	TxStatusExpired                      // Transaction is removed as it exceeds the max cache time
+	TxStatusEvicted                      // Transaction is evicted to make room for other ones
+	TxStatusDropped                      // Transaction is dropped on block commit as the sender can't afford it
+)
+
+var txStatusNames = map[TxStatusKind]string{
+	TxStatusUnknown:  "unknown",
+	TxStatusPending:  "pending",
+	TxStatusQueued:   "queued",
+	TxStatusIncluded: "included",
+	TxStatusReplaced: "replaced",
+	TxStatusExpired:  "expired",
+	TxStatusEvicted:  "evicted",
+	TxStatusDropped:  "dropped",
+}
+
+// String returns the name of the status.
+func (kind TxStatusKind) String() string {
+	if name, ok := txStatusNames[kind]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// TxStatus is the lifecycle status of a transaction.
+type TxStatus struct {
+	Kind       TxStatusKind
+	ReplacedBy types.Hash // Hash of the replacing transaction, only set if the transaction is replaced
+	Time       time.Time  // Time when the transaction is removed from txpool, zero if it's still in txpool
+}
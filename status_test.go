@@ -0,0 +1,56 @@
+package txpool
+
+import (
+	"github.com/DSiSc/craft/types"
+	"github.com/DSiSc/monkey"
+	"github.com/DSiSc/txpool/common"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"testing"
+)
+
+func TestTxStatusKind_String(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("pending", TxStatusPending.String())
+	assert.Equal("replaced", TxStatusReplaced.String())
+	assert.Equal("unknown", TxStatusKind(100).String())
+}
+
+func TestTxPool_Status(t *testing.T) {
+	defer monkey.UnpatchAll()
+	newMockChain()
+	assert := assert.New(t)
+	var mockTxPoolConfig = TxPoolConfig{
+		GlobalSlots:    2,
+		MaxTrsPerBlock: 10,
+	}
+	txpool := NewTxPool(mockTxPoolConfig, NewMockEvent())
+	sameFromTxs := mock_samefrom_transactions(5)
+	sameFromTxs[3].Data.Price = big.NewInt(5)
+	from := *sameFromTxs[0].Data.From
+	assert.Equal(TxStatusUnknown, txpool.Status(common.TxHash(sameFromTxs[0])).Kind)
+
+	assert.Nil(txpool.AddTx(sameFromTxs[0]))
+	assert.Nil(txpool.AddTx(sameFromTxs[1]))
+	assert.Nil(txpool.AddTx(sameFromTxs[3]))
+	assert.Equal(TxStatus{Kind: TxStatusPending}, txpool.Status(common.TxHash(sameFromTxs[0])))
+	assert.Equal(TxStatus{Kind: TxStatusQueued}, txpool.Status(common.TxHash(sameFromTxs[3])))
+
+	// replaced by the pricier one
+	replacement := common.NewTransaction(0, from, big.NewInt(0), 0, big.NewInt(10), nil, from)
+	assert.Nil(txpool.AddTx(replacement))
+	status := txpool.Status(common.TxHash(sameFromTxs[0]))
+	assert.Equal(TxStatusReplaced, status.Kind)
+	assert.Equal(common.TxHash(replacement), status.ReplacedBy)
+	assert.False(status.Time.IsZero())
+
+	// evicted by the pricier one
+	pricier := mock_transactions(1)[0]
+	pricier.Data.Price = big.NewInt(100)
+	assert.Nil(txpool.AddTx(pricier))
+	assert.Equal(TxStatusEvicted, txpool.Status(common.TxHash(sameFromTxs[1])).Kind)
+
+	// included in block
+	txpool.DelTxs([]*types.Transaction{replacement})
+	assert.Equal(TxStatusIncluded, txpool.Status(common.TxHash(replacement)).Kind)
+}
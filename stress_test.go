@@ -0,0 +1,108 @@
+package txpool
+
+import (
+	"context"
+	"github.com/DSiSc/craft/types"
+	"github.com/DSiSc/monkey"
+	"github.com/DSiSc/txpool/common"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Test adding, reading and deleting txs concurrently, while blocks are committed and txpool is reconciled by the
+// reorg loop. Run with -race to detect data races.
+func TestTxPool_ConcurrentStress(t *testing.T) {
+	defer monkey.UnpatchAll()
+	chain := newMockChain()
+	assert := assert.New(t)
+	txpool := NewTxPool(DefaultTxPoolConfig, NewMockEvent())
+	instance := txpool.(*TxPool)
+	assert.Nil(txpool.Start(context.Background()))
+	defer txpool.Stop()
+	accountTxs := mock_accounts_transactions(8, 50)
+
+	// adders add the txs of each account in order, some of them in batch
+	var adders sync.WaitGroup
+	for _, txs := range accountTxs {
+		adders.Add(1)
+		go func(txs []*types.Transaction) {
+			defer adders.Done()
+			for i := 0; i < len(txs); i += 5 {
+				if i%10 == 0 {
+					for _, err := range txpool.AddTxs(txs[i : i+5]) {
+						assert.Nil(err)
+					}
+					continue
+				}
+				for _, tx := range txs[i : i+5] {
+					assert.Nil(txpool.AddTx(tx))
+				}
+			}
+		}(txs)
+	}
+
+	// readers read txpool concurrently, the pending txs of each account are always contiguous
+	quit := make(chan struct{})
+	var readers sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-quit:
+					return
+				default:
+				}
+				next := make(map[types.Address]uint64)
+				for _, tx := range txpool.GetTxsWithLimits(100, 0, 0) {
+					if nonce, ok := next[*tx.Data.From]; ok {
+						assert.Equal(nonce, tx.Data.AccountNonce)
+					}
+					next[*tx.Data.From] = tx.Data.AccountNonce + 1
+				}
+				for address, txs := range txpool.Pending() {
+					for i := 1; i < len(txs); i++ {
+						assert.Equal(txs[i-1].Data.AccountNonce+1, txs[i].Data.AccountNonce)
+					}
+					txpool.ContentFrom(address)
+					txpool.Nonce(address)
+					txpool.GetPoolNonce(address)
+				}
+				txpool.Queued()
+				txpool.Stats()
+				txpool.Status(common.TxHash(accountTxs[0][0]))
+			}
+		}()
+	}
+
+	// producer packages blocks from txpool and commits them, until all the txs are committed
+	committed := 0
+	deadline := time.Now().Add(time.Minute)
+	for committed < 400 && time.Now().Before(deadline) {
+		block := txpool.GetTxsWithLimits(20, 0, 0)
+		chain.commit(block)
+		if len(block)%2 == 0 {
+			txpool.DelTxs(block)
+		}
+		instance.updateChainInstance(nil)
+		committed += len(block)
+	}
+	adders.Wait()
+	close(quit)
+	readers.Wait()
+	<-instance.scheduleReorg(false)
+
+	assert.Equal(400, committed)
+	pending, queued := txpool.Stats()
+	assert.Equal(0, pending)
+	assert.Equal(0, queued)
+	for _, txs := range accountTxs {
+		for _, tx := range txs {
+			assert.Equal(TxStatusIncluded, txpool.Status(common.TxHash(tx)).Kind)
+		}
+		assert.Equal(uint64(50), txpool.Nonce(*txs[0].Data.From))
+	}
+}
@@ -0,0 +1,51 @@
+package tools
+
+import (
+	"github.com/DSiSc/craft/types"
+	"sync"
+)
+
+// AccountSet is a thread safe set of addresses, used to mark the local accounts whose transactions are exempt
+// from the timeout and eviction of tx pool.
+type AccountSet struct {
+	mu       sync.RWMutex
+	accounts map[types.Address]struct{}
+}
+
+// NewAccountSet creates an account set containing the specified addresses.
+func NewAccountSet(addrs ...types.Address) *AccountSet {
+	set := &AccountSet{
+		accounts: make(map[types.Address]struct{}),
+	}
+	for _, addr := range addrs {
+		set.accounts[addr] = struct{}{}
+	}
+	return set
+}
+
+// Add inserts an address into the set.
+func (self *AccountSet) Add(addr types.Address) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	self.accounts[addr] = struct{}{}
+}
+
+// Contains checks whether the address is in the set.
+func (self *AccountSet) Contains(addr types.Address) bool {
+	self.mu.RLock()
+	defer self.mu.RUnlock()
+	_, exist := self.accounts[addr]
+	return exist
+}
+
+// ContainsTx checks whether the sender of tx is in the set.
+func (self *AccountSet) ContainsTx(tx *types.Transaction) bool {
+	return tx.Data.From != nil && self.Contains(*tx.Data.From)
+}
+
+// Len returns the number of addresses in the set.
+func (self *AccountSet) Len() int {
+	self.mu.RLock()
+	defer self.mu.RUnlock()
+	return len(self.accounts)
+}
@@ -0,0 +1,22 @@
+package tools
+
+import (
+	"github.com/DSiSc/txpool/common"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestAccountSet(t *testing.T) {
+	assert := assert.New(t)
+	set := NewAccountSet(mockAddr)
+	assert.Equal(1, set.Len())
+	assert.True(set.Contains(mockAddr))
+	assert.True(set.ContainsTx(mockTransaction()))
+
+	addr := common.HexToAddress("0xb94f5374fce5edbc8e2a8697c15331677e6ebf0b")
+	assert.False(set.Contains(addr))
+	set.Add(addr)
+	set.Add(addr)
+	assert.True(set.Contains(addr))
+	assert.Equal(2, set.Len())
+}
@@ -1,218 +1,387 @@
 package tools
 
 import (
-	"container/list"
+	"container/heap"
 	"errors"
 	"github.com/DSiSc/craft/types"
+	"github.com/DSiSc/txpool/common"
+	"hash/fnv"
+	"math/big"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 var (
-	DuplicateError    = errors.New("duplicate insert")
-	BufferIsFullError = errors.New("buffer is full")
+	DuplicateError          = errors.New("duplicate insert")
+	BufferIsFullError       = errors.New("buffer is full")
+	ReplaceUnderpricedError = errors.New("replacement underpriced")
 )
 
+// bufferShards is the number of the account shards of ListBuffer.
+const bufferShards = 32
+
+// ShardOf returns the index of the shard in [0, shards) which the account is distributed to.
+func ShardOf(addr types.Address, shards int) int {
+	hasher := fnv.New32a()
+	hasher.Write(addr[:])
+	return int(hasher.Sum32() % uint32(shards))
+}
+
 // TimedTransaction contains a transaction with the time added to buffer
 type TimedTransaction struct {
 	Tx        *types.Transaction
 	TimeStamp time.Time
 }
 
-// ListBuffer is a Tx list buffer implementation.
+// txGroupShard holds the tx groups of the accounts distributed to the shard.
+type txGroupShard struct {
+	mu            sync.Mutex
+	timedTxGroups map[types.Address]*TxList
+}
+
+// ListBuffer is a Tx list buffer implementation, it's safe for concurrent use. The tx groups are sharded by account,
+// so that the txs of different accounts can be added in parallel, and all the txs are indexed by hash globally.
+// Locks are always acquired in the order of shard, priced and index.
 type ListBuffer struct {
-	limit         uint64
-	maxCacheTime  uint64
-	len           int
-	txs           map[types.Hash]*types.Transaction
-	timedTxGroups map[types.Address]*list.List
+	limit        uint64
+	maxCacheTime uint64
+	priceBump    uint64
+	len          int64  // number of txs, accessed atomically
+	version      uint64 // number of modifications, accessed atomically
+	shards       [bufferShards]*txGroupShard
+	txsMu        sync.RWMutex
+	txs          map[types.Hash]*TimedTransaction // global index of the txs in buffer
+	pricedMu     sync.Mutex
+	priced       *cheapHeap  // all txs sorted by price, removed txs are discarded lazily
+	locals       *AccountSet // accounts whose txs are exempt from timeout and eviction
 }
 
-// NewListBuffer create a Tx list buffer instance
-func NewListBuffer(limit uint64, maxCacheTime uint64) *ListBuffer {
-	return &ListBuffer{
-		limit:         limit,
-		maxCacheTime:  maxCacheTime,
-		len:           0,
-		timedTxGroups: make(map[types.Address]*list.List),
-		txs:           make(map[types.Hash]*types.Transaction),
+// NewListBuffer create a Tx list buffer instance, priceBump is the minimum price bump percentage to replace an
+// exist tx with same nonce.
+func NewListBuffer(limit uint64, maxCacheTime uint64, priceBump uint64) *ListBuffer {
+	buffer := &ListBuffer{
+		limit:        limit,
+		maxCacheTime: maxCacheTime,
+		priceBump:    priceBump,
+		len:          0,
+		txs:          make(map[types.Hash]*TimedTransaction),
+		priced:       &cheapHeap{},
 	}
+	for i := range buffer.shards {
+		buffer.shards[i] = &txGroupShard{timedTxGroups: make(map[types.Address]*TxList)}
+	}
+	return buffer
 }
 
-// AddTx add an element to list buffer
+// AddTx add an element to list buffer. The limit of list buffer is not enforced here, the caller is responsible for
+// making room with IsFull, IsOverflowed and Cheapest.
 func (self *ListBuffer) AddTx(tx *types.Transaction) error {
+	return self.AddTimedTx(&TimedTransaction{Tx: tx, TimeStamp: time.Now()})
+}
+
+// AddTimedTx add an element to list buffer with its timestamp kept, so moving tx between list buffers doesn't
+// restart its lifetime.
+func (self *ListBuffer) AddTimedTx(timedTx *TimedTransaction) error {
+	tx := timedTx.Tx
 	hash := tx.Hash.Load().(types.Hash)
-	if self.txs[hash] != nil {
+	from := *tx.Data.From
+	shard := self.shard(from)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	if self.GetTx(hash) != nil {
 		return DuplicateError
-	} else {
-		self.txs[hash] = tx
 	}
 
-	// insert timedTx into the correct index in self.timedTxGroups
-	if self.timedTxGroups[*tx.Data.From] == nil {
-		self.timedTxGroups[*tx.Data.From] = list.New()
-	}
-	sameFromTxs := self.timedTxGroups[*tx.Data.From]
-	if self.insertOrReplace(sameFromTxs, tx) {
-		return nil
-	}
-
-	// check limit
-	if uint64(self.len) <= self.limit {
-		return nil
+	// insert timedTx into the correct index in shard.timedTxGroups
+	if shard.timedTxGroups[from] == nil {
+		shard.timedTxGroups[from] = NewTxList()
 	}
+	_, err := self.insertOrReplace(shard.timedTxGroups[from], timedTx)
+	return err
+}
 
-	// check timeout tx in self group
-	if self.removeTimeOutTx(sameFromTxs) {
-		return nil
+// GetTx get an element from list buffer
+func (self *ListBuffer) GetTx(hash types.Hash) *types.Transaction {
+	self.txsMu.RLock()
+	defer self.txsMu.RUnlock()
+	if timedTx := self.txs[hash]; timedTx != nil {
+		return timedTx.Tx
 	}
+	return nil
+}
 
-	// delete timeout tx in other group
-	if self.RemoveTimeOutTx() {
-		return nil
+// GetTxByNonce get the tx with specified nonce of the account from list buffer
+func (self *ListBuffer) GetTxByNonce(from types.Address, nonce uint64) *types.Transaction {
+	shard := self.shard(from)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	if l := shard.timedTxGroups[from]; l != nil {
+		if timedTx := l.Get(nonce); timedTx != nil {
+			return timedTx.Tx
+		}
 	}
+	return nil
+}
 
-	// remove last tx
-	backTimedTx := sameFromTxs.Back().Value.(*TimedTransaction)
-	self.RemoveTx(backTimedTx.Tx.Hash.Load().(types.Hash))
-	if backTimedTx.Tx.Data.AccountNonce == tx.Data.AccountNonce {
-		return BufferIsFullError
+// Cheapest returns the non-local tx with lowest price in list buffer, the newer one will be returned if there are
+// several txs with the same price.
+func (self *ListBuffer) Cheapest() *types.Transaction {
+	self.pricedMu.Lock()
+	defer self.pricedMu.Unlock()
+	for self.priced.Len() > 0 {
+		timedTx := self.priced.priceHeap[0]
+		if self.indexed(timedTx) && !self.isLocal(*timedTx.Tx.Data.From) {
+			return timedTx.Tx
+		}
+		heap.Pop(self.priced)
 	}
 	return nil
 }
 
-// GetTx get an element from list buffer
-func (self *ListBuffer) GetTx(hash types.Hash) *types.Transaction {
-	return self.txs[hash]
+// IsFull returns true if the number of txs reaches the limit of list buffer.
+func (self *ListBuffer) IsFull() bool {
+	return uint64(self.Len()) >= self.limit
+}
+
+// IsOverflowed returns true if the number of txs exceeds the limit of list buffer.
+func (self *ListBuffer) IsOverflowed() bool {
+	return uint64(self.Len()) > self.limit
 }
 
 // RemoveTx remove an element from list buffer
 func (self *ListBuffer) RemoveTx(hash types.Hash) {
-	if elem := self.txs[hash]; elem != nil {
-		delete(self.txs, hash)
-		self.deleteTx(*elem.Data.From, elem.Data.AccountNonce)
-		self.decLen()
+	tx := self.GetTx(hash)
+	if tx == nil {
+		return
 	}
+	shard := self.shard(*tx.Data.From)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	self.removeTx(shard, hash)
 }
 
-// RemoveOlderTx remove tx nonce less than specified nonce from buffer
-func (self *ListBuffer) RemoveOlderTx(addr types.Address, nonce uint64) {
-	if l := self.timedTxGroups[addr]; l != nil {
-		for firstE := l.Front(); firstE != nil; {
-			firstTx := firstE.Value.(*TimedTransaction)
-			firstTxHash := firstTx.Tx.Hash.Load().(types.Hash)
-			if firstTx.Tx.Data.AccountNonce > nonce {
-				return
-			}
-
-			nextE := firstE.Next()
-			if firstTx.Tx.Data.AccountNonce <= nonce {
-				delete(self.txs, firstTxHash)
-				l.Remove(firstE)
-				self.decLen()
-			}
-			firstE = nextE
+// RemoveOlderTx remove tx nonce not greater than specified nonce from buffer, the removed txs are returned.
+func (self *ListBuffer) RemoveOlderTx(addr types.Address, nonce uint64) []*types.Transaction {
+	shard := self.shard(addr)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	var removed []*types.Transaction
+	if l := shard.timedTxGroups[addr]; l != nil {
+		for _, timedTx := range l.RemoveTo(nonce) {
+			self.unindex(timedTx.Tx.Hash.Load().(types.Hash))
+			self.decLen()
+			removed = append(removed, timedTx.Tx)
 		}
 		if l.Len() <= 0 {
-			delete(self.timedTxGroups, addr)
+			delete(shard.timedTxGroups, addr)
 		}
 	}
+	return removed
 }
 
-// RemoveTimeOutTx remove an timeout Tx from list buffer, return true if exists timeout Tx.
-func (self *ListBuffer) RemoveTimeOutTx() bool {
-	for _, timedTxGroup := range self.timedTxGroups {
-		if self.removeTimeOutTx(timedTxGroup) {
-			return true
+// SetLocals sets the local accounts of list buffer, txs of them are exempt from timeout and eviction.
+func (self *ListBuffer) SetLocals(locals *AccountSet) {
+	self.locals = locals
+}
+
+// ExpiredTxs returns the non-local txs staying in list buffer longer than the max cache time, the caller is
+// responsible for removing them.
+func (self *ListBuffer) ExpiredTxs() []*types.Transaction {
+	deadline := time.Now().Add(-time.Duration(self.maxCacheTime) * time.Second)
+	txs := make([]*types.Transaction, 0)
+	for _, shard := range self.shards {
+		shard.mu.Lock()
+		for addr, l := range shard.timedTxGroups {
+			if self.isLocal(addr) {
+				continue
+			}
+			for e := l.Front(); e != nil; e = e.Next() {
+				if timedTx := e.Value; timedTx.TimeStamp.Before(deadline) {
+					txs = append(txs, timedTx.Tx)
+				}
+			}
 		}
+		shard.mu.Unlock()
 	}
-	return false
+	return txs
 }
 
-// TimedTxGroups returns the tx groups of ListBuffer.
-func (self *ListBuffer) TimedTxGroups() map[types.Address]*list.List {
-	return self.timedTxGroups
+// TimedTxGroups returns a copy of the tx groups of ListBuffer. The groups themselves are shared with ListBuffer, so
+// a group must not be read while the txs of its account are being modified.
+func (self *ListBuffer) TimedTxGroups() map[types.Address]*TxList {
+	groups := make(map[types.Address]*TxList)
+	for _, shard := range self.shards {
+		shard.mu.Lock()
+		for addr, l := range shard.timedTxGroups {
+			groups[addr] = l
+		}
+		shard.mu.Unlock()
+	}
+	return groups
+}
+
+// TxGroup returns the tx group of the account, nil will be returned if there is no tx of the account. The group
+// is shared with ListBuffer, so it must not be read while the txs of the account are being modified.
+func (self *ListBuffer) TxGroup(from types.Address) *TxList {
+	shard := self.shard(from)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	return shard.timedTxGroups[from]
+}
+
+// Accounts returns the accounts having txs in ListBuffer.
+func (self *ListBuffer) Accounts() []types.Address {
+	accounts := make([]types.Address, 0)
+	for _, shard := range self.shards {
+		shard.mu.Lock()
+		for addr := range shard.timedTxGroups {
+			accounts = append(accounts, addr)
+		}
+		shard.mu.Unlock()
+	}
+	return accounts
 }
 
 // NonceInBuffer returns the nonce in buffer.
 func (self *ListBuffer) NonceInBuffer(from types.Address) uint64 {
-	if timedTxGroup := self.timedTxGroups[from]; timedTxGroup != nil {
-		timedTx := timedTxGroup.Back().Value.(*TimedTransaction)
+	shard := self.shard(from)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	if timedTxGroup := shard.timedTxGroups[from]; timedTxGroup != nil {
+		timedTx := timedTxGroup.Back().Value
 		return timedTx.Tx.Data.AccountNonce
 	}
 	return 0
 }
 
+// AccountLen returns the number of txs of the account in ListBuffer.
+func (self *ListBuffer) AccountLen(from types.Address) int {
+	shard := self.shard(from)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	if timedTxGroup := shard.timedTxGroups[from]; timedTxGroup != nil {
+		return timedTxGroup.Len()
+	}
+	return 0
+}
+
 // Len returns the number of txs of ListBuffer.
 func (self *ListBuffer) Len() int {
-	return self.len
+	return int(atomic.LoadInt64(&self.len))
+}
+
+// Version returns the number of modifications of ListBuffer, it's increased whenever a tx is added or removed.
+func (self *ListBuffer) Version() uint64 {
+	return atomic.LoadUint64(&self.version)
+}
+
+// get the shard which the account is distributed to
+func (self *ListBuffer) shard(addr types.Address) *txGroupShard {
+	return self.shards[ShardOf(addr, bufferShards)]
 }
 
 // insert into group if not exist same nonce tx, else update the exist tx. return true if exists same nonce tx.
-func (self *ListBuffer) insertOrReplace(sameFromTxs *list.List, tx *types.Transaction) bool {
-	timedTx := &TimedTransaction{
-		Tx:        tx,
-		TimeStamp: time.Now(),
+// ReplaceUnderpricedError will be returned if the price of tx is not high enough to replace the exist one.
+func (self *ListBuffer) insertOrReplace(sameFromTxs *TxList, timedTx *TimedTransaction) (bool, error) {
+	old := sameFromTxs.Get(timedTx.Tx.Data.AccountNonce)
+	if old != nil && !self.priceBumped(old.Tx, timedTx.Tx) {
+		return false, ReplaceUnderpricedError
 	}
+	sameFromTxs.Put(timedTx)
+	if old != nil {
+		// delete previous tx in txList cache
+		self.unindex(old.Tx.Hash.Load().(types.Hash))
+	} else {
+		self.incLen()
+	}
+	self.index(timedTx)
+	self.addPriced(timedTx)
+	return old != nil, nil
+}
 
-	for e := sameFromTxs.Back(); e != nil; e = e.Prev() {
-		eTx := e.Value.(*TimedTransaction)
-		if eTx.Tx.Data.AccountNonce == tx.Data.AccountNonce {
-			e.Value = timedTx
+// check whether the price of new tx exceeds the old one by the price bump percentage.
+func (self *ListBuffer) priceBumped(old, tx *types.Transaction) bool {
+	oldPrice, newPrice := common.TxPrice(old), common.TxPrice(tx)
+	threshold := new(big.Int).Mul(oldPrice, big.NewInt(int64(100+self.priceBump)))
+	threshold.Div(threshold, big.NewInt(100))
+	return newPrice.Cmp(oldPrice) > 0 && newPrice.Cmp(threshold) >= 0
+}
 
-			// delete previous tx in txList cache
-			eHash := eTx.Tx.Hash.Load().(types.Hash)
-			delete(self.txs, eHash)
-			return true
-		}
-		if eTx.Tx.Data.AccountNonce < tx.Data.AccountNonce {
-			sameFromTxs.InsertAfter(timedTx, e)
-			self.incLen()
-			return false
+// check whether the account is local
+func (self *ListBuffer) isLocal(addr types.Address) bool {
+	return self.locals != nil && self.locals.Contains(addr)
+}
+
+// remove Tx from list buffer, the lock of shard which the Tx belongs to must be held.
+func (self *ListBuffer) removeTx(shard *txGroupShard, hash types.Hash) {
+	if elem := self.unindex(hash); elem != nil {
+		self.deleteTx(shard, *elem.Tx.Data.From, elem.Tx.Data.AccountNonce)
+		self.decLen()
+	}
+}
+
+// delete Tx from shard.timedTxGroups
+func (self *ListBuffer) deleteTx(shard *txGroupShard, addr types.Address, nonce uint64) {
+	if l := shard.timedTxGroups[addr]; l != nil {
+		l.Remove(nonce)
+		if l.Len() <= 0 {
+			delete(shard.timedTxGroups, addr)
 		}
 	}
+}
 
-	sameFromTxs.PushFront(timedTx)
-	self.incLen()
-	return false
+// add Tx to the global index
+func (self *ListBuffer) index(timedTx *TimedTransaction) {
+	self.txsMu.Lock()
+	defer self.txsMu.Unlock()
+	self.txs[timedTx.Tx.Hash.Load().(types.Hash)] = timedTx
+	atomic.AddUint64(&self.version, 1)
 }
 
-// remove an timeout Tx from list buffer, return true if exists timeout Tx.
-func (self *ListBuffer) removeTimeOutTx(timedTxGroup *list.List) bool {
-	fontTx := timedTxGroup.Front().Value.(*TimedTransaction)
-	if time.Now().After(fontTx.TimeStamp.Add(time.Duration(self.maxCacheTime) * time.Second)) {
-		lastTx := timedTxGroup.Back().Value.(*TimedTransaction)
-		self.RemoveTx(lastTx.Tx.Hash.Load().(types.Hash))
-		return true
-	} else {
-		return false
+// remove Tx from the global index, return the removed Tx.
+func (self *ListBuffer) unindex(hash types.Hash) *TimedTransaction {
+	self.txsMu.Lock()
+	defer self.txsMu.Unlock()
+	timedTx := self.txs[hash]
+	if timedTx != nil {
+		delete(self.txs, hash)
+		atomic.AddUint64(&self.version, 1)
 	}
+	return timedTx
 }
 
-// delete Tx from self.timedTxGroups
-func (self *ListBuffer) deleteTx(addr types.Address, nonce uint64) {
-	if l := self.timedTxGroups[addr]; l != nil {
-		for firstE := l.Front(); firstE != nil; firstE = firstE.Next() {
-			firstTx := firstE.Value.(*TimedTransaction)
-			if firstTx.Tx.Data.AccountNonce > nonce {
-				return
-			}
-			if firstTx.Tx.Data.AccountNonce == nonce {
-				l.Remove(firstE)
-				break
-			}
-		}
-		if l.Len() <= 0 {
-			delete(self.timedTxGroups, addr)
-		}
+// check whether the Tx is still in the global index
+func (self *ListBuffer) indexed(timedTx *TimedTransaction) bool {
+	self.txsMu.RLock()
+	defer self.txsMu.RUnlock()
+	return self.txs[timedTx.Tx.Hash.Load().(types.Hash)] == timedTx
+}
+
+// add tx to the price heap, the heap is rebuilt if there are too many removed txs in it.
+func (self *ListBuffer) addPriced(timedTx *TimedTransaction) {
+	self.pricedMu.Lock()
+	defer self.pricedMu.Unlock()
+	heap.Push(self.priced, timedTx)
+	if self.priced.Len() <= 2*self.Len()+64 {
+		return
+	}
+	self.txsMu.RLock()
+	items := make(priceHeap, 0, len(self.txs))
+	for _, indexed := range self.txs {
+		items = append(items, indexed)
 	}
+	self.txsMu.RUnlock()
+	self.priced.priceHeap = items
+	heap.Init(self.priced)
 }
 
 // increase length of buffer
 func (self *ListBuffer) incLen() {
-	self.len++
+	atomic.AddInt64(&self.len, 1)
 }
 
 // decrease length of buffer
 func (self *ListBuffer) decLen() {
-	self.len--
+	atomic.AddInt64(&self.len, -1)
 }
@@ -5,7 +5,9 @@ import (
 	"github.com/DSiSc/txpool/common"
 	"github.com/stretchr/testify/assert"
 	"math/big"
+	"sync"
 	"testing"
+	"time"
 )
 
 var (
@@ -31,14 +33,14 @@ func mockTransaction1(hash types.Hash, from types.Address) *types.Transaction {
 
 func TestNewListBuffer(t *testing.T) {
 	assert := assert.New(t)
-	lb := NewListBuffer(100, 100)
+	lb := NewListBuffer(100, 100, 10)
 	assert.NotNil(lb)
 	assert.Equal(0, lb.Len())
 }
 
 func TestListBuffer_AddElement(t *testing.T) {
 	assert := assert.New(t)
-	lb := NewListBuffer(100, 100)
+	lb := NewListBuffer(100, 100, 10)
 	assert.NotNil(lb)
 	tx := mockTransaction()
 	assert.Nil(lb.AddTx(tx))
@@ -47,7 +49,7 @@ func TestListBuffer_AddElement(t *testing.T) {
 
 func TestListBuffer_GetElement(t *testing.T) {
 	assert := assert.New(t)
-	lb := NewListBuffer(100, 100)
+	lb := NewListBuffer(100, 100, 10)
 	assert.NotNil(lb)
 	tx := mockTransaction()
 	assert.Nil(lb.AddTx(tx))
@@ -56,10 +58,13 @@ func TestListBuffer_GetElement(t *testing.T) {
 
 func TestListBuffer_Front(t *testing.T) {
 	assert := assert.New(t)
-	lb := NewListBuffer(100, 100)
+	lb := NewListBuffer(100, 100, 10)
 	assert.NotNil(lb)
 	assert.Nil(lb.AddTx(mockTransaction1(common.HexToHash("0x776a2bbddcb56d8bc5a97ca8058a76fa5bb27b2a589c80cf508b86d083bdd191"), common.HexToAddress("0xa94f5374fce5edbc8e2a8697c15331677e6ebf0b"))))
-	assert.Nil(lb.AddTx(mockTransaction1(common.HexToHash("0x676a2bbddcb56d8bc5a97ca8058a76fa5bb27b2a589c80cf508b86d083bdd191"), common.HexToAddress("0xa94f5374fce5edbc8e2a8697c15331677e6ebf0b"))))
+	tx := mockTransaction1(common.HexToHash("0x676a2bbddcb56d8bc5a97ca8058a76fa5bb27b2a589c80cf508b86d083bdd191"),
+		common.HexToAddress("0xa94f5374fce5edbc8e2a8697c15331677e6ebf0b"))
+	tx.Data.AccountNonce = 1
+	assert.Nil(lb.AddTx(tx))
 	assert.Nil(lb.AddTx(mockTransaction1(common.HexToHash("0x576a2bbddcb56d8bc5a97ca8058a76fa5bb27b2a589c80cf508b86d083bdd191"), common.HexToAddress("0xb94f5374fce5edbc8e2a8697c15331677e6ebf0b"))))
 	e := lb.TimedTxGroups()
 	assert.Equal(2, len(e))
@@ -67,7 +72,7 @@ func TestListBuffer_Front(t *testing.T) {
 
 func TestListBuffer_Len(t *testing.T) {
 	assert := assert.New(t)
-	lb := NewListBuffer(100, 100)
+	lb := NewListBuffer(100, 100, 10)
 	assert.NotNil(lb)
 	tx := mockTransaction()
 	assert.Nil(lb.AddTx(tx))
@@ -76,7 +81,7 @@ func TestListBuffer_Len(t *testing.T) {
 
 func TestListBuffer_AddTx(t *testing.T) {
 	assert := assert.New(t)
-	lb := NewListBuffer(100, 100)
+	lb := NewListBuffer(100, 100, 10)
 	assert.NotNil(lb)
 	tx := mockTransaction()
 	assert.Nil(lb.AddTx(tx))
@@ -92,7 +97,7 @@ func TestListBuffer_AddTx(t *testing.T) {
 
 func TestListBuffer_RemoveOlderTx(t *testing.T) {
 	assert := assert.New(t)
-	lb := NewListBuffer(100, 100)
+	lb := NewListBuffer(100, 100, 10)
 	assert.NotNil(lb)
 	tx := mockTransaction1(mockHash, mockAddr)
 	assert.Nil(lb.AddTx(tx))
@@ -105,8 +110,185 @@ func TestListBuffer_RemoveOlderTx(t *testing.T) {
 	tx.Data.AccountNonce = 2
 	assert.Nil(lb.AddTx(tx))
 
-	lb.RemoveOlderTx(mockAddr, 1)
+	removed := lb.RemoveOlderTx(mockAddr, 1)
+	assert.Equal(2, len(removed))
+	assert.Equal(uint64(1), removed[1].Data.AccountNonce)
 	assert.Equal(1, lb.Len())
 	assert.Equal(1, len(lb.txs))
-	assert.Equal(1, lb.timedTxGroups[mockAddr].Len())
+	assert.Equal(1, lb.TxGroup(mockAddr).Len())
+
+	// each added and removed tx changes the version
+	assert.Equal(uint64(5), lb.Version())
+	lb.RemoveOlderTx(mockAddr, 1)
+	lb.RemoveTx(mockHash)
+	assert.Equal(uint64(5), lb.Version())
+}
+
+func TestTxsByPriceAndNonce(t *testing.T) {
+	assert := assert.New(t)
+	addr1 := common.HexToAddress("0xa94f5374fce5edbc8e2a8697c15331677e6ebf0b")
+	addr2 := common.HexToAddress("0xb94f5374fce5edbc8e2a8697c15331677e6ebf0b")
+	mockTimedTx := func(from types.Address, nonce uint64, price int64) *TimedTransaction {
+		tx := mockTransaction1(mockHash, from)
+		tx.Data.AccountNonce = nonce
+		tx.Data.Price = big.NewInt(price)
+		return &TimedTransaction{Tx: tx}
+	}
+	txs := map[types.Address][]*TimedTransaction{
+		addr1: {mockTimedTx(addr1, 0, 1), mockTimedTx(addr1, 1, 5)},
+		addr2: {mockTimedTx(addr2, 0, 3), mockTimedTx(addr2, 1, 2)},
+	}
+	sortedTxs := NewTxsByPriceAndNonce(txs)
+	expected := []struct {
+		from  types.Address
+		nonce uint64
+	}{{addr2, 0}, {addr2, 1}, {addr1, 0}, {addr1, 1}}
+	for _, e := range expected {
+		tx := sortedTxs.Peek()
+		assert.NotNil(tx)
+		assert.Equal(e.from, *tx.Data.From)
+		assert.Equal(e.nonce, tx.Data.AccountNonce)
+		sortedTxs.Shift()
+	}
+	assert.Nil(sortedTxs.Peek())
+
+	// pop discards the remaining txs of the account
+	sortedTxs = NewTxsByPriceAndNonce(map[types.Address][]*TimedTransaction{
+		addr1: {mockTimedTx(addr1, 0, 1), mockTimedTx(addr1, 1, 5)},
+		addr2: {mockTimedTx(addr2, 0, 3), mockTimedTx(addr2, 1, 2)},
+	})
+	sortedTxs.Pop()
+	assert.Equal(addr1, *sortedTxs.Peek().Data.From)
+	sortedTxs.Shift()
+	assert.Equal(uint64(1), sortedTxs.Peek().Data.AccountNonce)
+	sortedTxs.Shift()
+	assert.Nil(sortedTxs.Peek())
+}
+
+func TestListBuffer_ReplaceUnderpriced(t *testing.T) {
+	assert := assert.New(t)
+	lb := NewListBuffer(100, 100, 10)
+	tx := mockTransaction1(mockHash, mockAddr)
+	tx.Data.Price = big.NewInt(100)
+	assert.Nil(lb.AddTx(tx))
+
+	tx1 := mockTransaction1(mockHash1, mockAddr)
+	tx1.Data.Price = big.NewInt(109)
+	assert.Equal(ReplaceUnderpricedError, lb.AddTx(tx1))
+	assert.Nil(lb.GetTx(mockHash1))
+	assert.NotNil(lb.GetTx(mockHash))
+
+	tx1.Data.Price = big.NewInt(110)
+	assert.Nil(lb.AddTx(tx1))
+	assert.Nil(lb.GetTx(mockHash))
+	assert.NotNil(lb.GetTx(mockHash1))
+	assert.Equal(1, lb.Len())
+}
+
+func TestListBuffer_Cheapest(t *testing.T) {
+	assert := assert.New(t)
+	lb := NewListBuffer(100, 100, 10)
+	assert.Nil(lb.Cheapest())
+	tx := mockTransaction1(mockHash, mockAddr)
+	tx.Data.Price = big.NewInt(5)
+	assert.Nil(lb.AddTx(tx))
+	tx1 := mockTransaction1(mockHash1, common.HexToAddress("0xb94f5374fce5edbc8e2a8697c15331677e6ebf0b"))
+	tx1.Data.Price = big.NewInt(3)
+	assert.Nil(lb.AddTx(tx1))
+	tx2 := mockTransaction1(mockHash2, mockAddr)
+	tx2.Data.AccountNonce = 1
+	tx2.Data.Price = big.NewInt(8)
+	assert.Nil(lb.AddTx(tx2))
+	assert.Equal(tx1, lb.Cheapest())
+
+	lb.RemoveTx(mockHash1)
+	assert.Equal(tx, lb.Cheapest())
+	assert.Equal(tx2, lb.GetTxByNonce(mockAddr, 1))
+	assert.Nil(lb.GetTxByNonce(mockAddr, 2))
+}
+
+func TestListBuffer_Locals(t *testing.T) {
+	assert := assert.New(t)
+	localAddr := common.HexToAddress("0xb94f5374fce5edbc8e2a8697c15331677e6ebf0b")
+	lb := NewListBuffer(1, 0, 10)
+	lb.SetLocals(NewAccountSet(localAddr))
+	tx := mockTransaction1(mockHash, localAddr)
+	assert.Nil(lb.AddTx(tx))
+
+	// local tx neither expires nor is the cheapest
+	assert.Equal(0, len(lb.ExpiredTxs()))
+	assert.Nil(lb.Cheapest())
+
+	// the limit is left to the caller, only the remote tx is the candidate to make room
+	tx1 := mockTransaction1(mockHash1, localAddr)
+	tx1.Data.AccountNonce = 1
+	assert.Nil(lb.AddTx(tx1))
+	tx2 := mockTransaction1(mockHash2, mockAddr)
+	assert.Nil(lb.AddTx(tx2))
+	assert.Equal(3, lb.Len())
+	assert.True(lb.IsOverflowed())
+	assert.Equal(tx2, lb.Cheapest())
+
+	lb.locals = nil
+	assert.Equal(3, len(lb.ExpiredTxs()))
+}
+
+func TestListBuffer_ExpiredTxs(t *testing.T) {
+	assert := assert.New(t)
+	localAddr := common.HexToAddress("0xb94f5374fce5edbc8e2a8697c15331677e6ebf0b")
+	lb := NewListBuffer(100, 0, 10)
+	lb.SetLocals(NewAccountSet(localAddr))
+	tx := mockTransaction1(mockHash, mockAddr)
+	assert.Nil(lb.AddTx(tx))
+	tx1 := mockTransaction1(mockHash1, mockAddr)
+	tx1.Data.AccountNonce = 1
+	assert.Nil(lb.AddTx(tx1))
+	assert.Nil(lb.AddTx(mockTransaction1(mockHash2, localAddr)))
+	assert.Equal(2, len(lb.ExpiredTxs()))
+
+	lb = NewListBuffer(100, 100, 10)
+	assert.Nil(lb.AddTx(tx))
+	assert.Equal(0, len(lb.ExpiredTxs()))
+
+	// the timestamp is kept when the tx is moved from another list buffer
+	timedTx := &TimedTransaction{Tx: tx1, TimeStamp: time.Now().Add(-time.Hour)}
+	assert.Nil(lb.AddTimedTx(timedTx))
+	assert.Equal(timedTx, lb.TxGroup(mockAddr).Get(1))
+	assert.Equal([]*types.Transaction{tx1}, lb.ExpiredTxs())
+}
+
+func TestShardOf(t *testing.T) {
+	assert := assert.New(t)
+	shard := ShardOf(mockAddr, bufferShards)
+	assert.True(shard >= 0 && shard < bufferShards)
+	assert.Equal(shard, ShardOf(mockAddr, bufferShards))
+	assert.Equal(0, ShardOf(mockAddr, 1))
+}
+
+func TestListBuffer_Concurrent(t *testing.T) {
+	assert := assert.New(t)
+	lb := NewListBuffer(1000, 100, 10)
+	var wg sync.WaitGroup
+	for a := 0; a < 16; a++ {
+		wg.Add(1)
+		go func(a int) {
+			defer wg.Done()
+			var from types.Address
+			from[0] = byte(a)
+			for i := 0; i < 20; i++ {
+				var hash types.Hash
+				hash[0], hash[1] = byte(a), byte(i)
+				tx := mockTransaction1(hash, from)
+				tx.Data.AccountNonce = uint64(i)
+				assert.Nil(lb.AddTx(tx))
+				assert.NotNil(lb.GetTx(hash))
+			}
+			lb.RemoveOlderTx(from, 9)
+		}(a)
+	}
+	wg.Wait()
+	assert.Equal(160, lb.Len())
+	assert.Equal(16, len(lb.Accounts()))
+	assert.Equal(160, len(lb.txs))
+	assert.NotNil(lb.Cheapest())
 }
@@ -0,0 +1,66 @@
+package tools
+
+import (
+	"container/list"
+	"github.com/DSiSc/craft/types"
+	"sync"
+)
+
+// lruEntry is the entry of LRU.
+type lruEntry struct {
+	key   types.Hash
+	value interface{}
+}
+
+// LRU is a thread safe cache keyed by hash with fixed size, the least recently used entry is discarded when the
+// cache is full.
+type LRU struct {
+	mu      sync.Mutex
+	size    int
+	entries *list.List
+	items   map[types.Hash]*list.Element
+}
+
+// NewLRU creates an LRU cache holding at most size entries.
+func NewLRU(size int) *LRU {
+	return &LRU{
+		size:    size,
+		entries: list.New(),
+		items:   make(map[types.Hash]*list.Element),
+	}
+}
+
+// Add adds or updates the value of key, the least recently used entry is discarded if the cache is full.
+func (self *LRU) Add(key types.Hash, value interface{}) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if elem, ok := self.items[key]; ok {
+		elem.Value.(*lruEntry).value = value
+		self.entries.MoveToFront(elem)
+		return
+	}
+	self.items[key] = self.entries.PushFront(&lruEntry{key: key, value: value})
+	if self.entries.Len() > self.size {
+		oldest := self.entries.Back()
+		self.entries.Remove(oldest)
+		delete(self.items, oldest.Value.(*lruEntry).key)
+	}
+}
+
+// Get returns the value of key, and marks it as recently used.
+func (self *LRU) Get(key types.Hash) (interface{}, bool) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if elem, ok := self.items[key]; ok {
+		self.entries.MoveToFront(elem)
+		return elem.Value.(*lruEntry).value, true
+	}
+	return nil, false
+}
+
+// Len returns the number of entries in the cache.
+func (self *LRU) Len() int {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	return self.entries.Len()
+}
@@ -0,0 +1,30 @@
+package tools
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestLRU(t *testing.T) {
+	assert := assert.New(t)
+	lru := NewLRU(2)
+	lru.Add(mockHash, 1)
+	lru.Add(mockHash1, 2)
+	assert.Equal(2, lru.Len())
+
+	// mockHash is recently used, mockHash1 will be discarded
+	value, ok := lru.Get(mockHash)
+	assert.True(ok)
+	assert.Equal(1, value)
+	lru.Add(mockHash2, 3)
+	assert.Equal(2, lru.Len())
+	_, ok = lru.Get(mockHash1)
+	assert.False(ok)
+
+	// update the exist entry
+	lru.Add(mockHash2, 4)
+	value, ok = lru.Get(mockHash2)
+	assert.True(ok)
+	assert.Equal(4, value)
+	assert.Equal(2, lru.Len())
+}
@@ -0,0 +1,173 @@
+package tools
+
+import (
+	"math/rand"
+)
+
+// maxTxListLevel is the maximum level of the skip list, which is enough for millions of txs of an account.
+const maxTxListLevel = 12
+
+// TxElement is an element of TxList.
+type TxElement struct {
+	Value *TimedTransaction
+	nonce uint64
+	next  []*TxElement // next element of each level
+	prev  *TxElement   // previous element of the lowest level, nil for the first element
+}
+
+// Next returns the next element or nil.
+func (e *TxElement) Next() *TxElement {
+	return e.next[0]
+}
+
+// Prev returns the previous element or nil.
+func (e *TxElement) Prev() *TxElement {
+	return e.prev
+}
+
+// TxList is the txs of an account sorted by nonce. It's implemented by skip list, so that inserting, looking up
+// and removing tx by nonce cost O(log n), while the first and the last tx are got in O(1). TxList is not safe for
+// concurrent use.
+type TxList struct {
+	head  TxElement  // sentinel whose next elements are the first elements of each level
+	tail  *TxElement // last element
+	level int        // current level of the skip list
+	len   int
+}
+
+// NewTxList creates an empty tx list.
+func NewTxList() *TxList {
+	return &TxList{
+		head:  TxElement{next: make([]*TxElement, maxTxListLevel)},
+		level: 1,
+	}
+}
+
+// Len returns the number of txs in list.
+func (l *TxList) Len() int {
+	return l.len
+}
+
+// Front returns the element with the lowest nonce, nil will be returned if the list is empty.
+func (l *TxList) Front() *TxElement {
+	return l.head.next[0]
+}
+
+// Back returns the element with the highest nonce, nil will be returned if the list is empty.
+func (l *TxList) Back() *TxElement {
+	return l.tail
+}
+
+// Get returns the tx with specified nonce, nil will be returned if not exist.
+func (l *TxList) Get(nonce uint64) *TimedTransaction {
+	if e := l.seek(nonce, nil); e != nil && e.nonce == nonce {
+		return e.Value
+	}
+	return nil
+}
+
+// Put inserts tx into list, the exist tx with same nonce is replaced and returned.
+func (l *TxList) Put(timedTx *TimedTransaction) *TimedTransaction {
+	nonce := timedTx.Tx.Data.AccountNonce
+	var update [maxTxListLevel]*TxElement
+	if e := l.seek(nonce, update[:]); e != nil && e.nonce == nonce {
+		old := e.Value
+		e.Value = timedTx
+		return old
+	}
+
+	level := randomTxListLevel()
+	for ; l.level < level; l.level++ {
+		update[l.level] = &l.head
+	}
+	e := &TxElement{Value: timedTx, nonce: nonce, next: make([]*TxElement, level)}
+	for i := 0; i < level; i++ {
+		e.next[i] = update[i].next[i]
+		update[i].next[i] = e
+	}
+	if update[0] != &l.head {
+		e.prev = update[0]
+	}
+	if e.next[0] != nil {
+		e.next[0].prev = e
+	} else {
+		l.tail = e
+	}
+	l.len++
+	return nil
+}
+
+// Remove removes the tx with specified nonce from list, the removed tx is returned.
+func (l *TxList) Remove(nonce uint64) *TimedTransaction {
+	var update [maxTxListLevel]*TxElement
+	e := l.seek(nonce, update[:])
+	if e == nil || e.nonce != nonce {
+		return nil
+	}
+	l.unlink(e, update[:])
+	return e.Value
+}
+
+// RemoveTo removes the txs whose nonce is not greater than specified nonce, the removed txs are returned in order.
+func (l *TxList) RemoveTo(nonce uint64) []*TimedTransaction {
+	var removed []*TimedTransaction
+	var update [maxTxListLevel]*TxElement
+	for i := range update {
+		update[i] = &l.head
+	}
+	for e := l.Front(); e != nil && e.nonce <= nonce; e = l.Front() {
+		l.unlink(e, update[:])
+		removed = append(removed, e.Value)
+	}
+	return removed
+}
+
+// Ready returns the txs contiguous from specified nonce in order.
+func (l *TxList) Ready(start uint64) []*TimedTransaction {
+	var ready []*TimedTransaction
+	for e := l.seek(start, nil); e != nil && e.nonce == start; e = e.Next() {
+		ready = append(ready, e.Value)
+		start++
+	}
+	return ready
+}
+
+// seek returns the first element whose nonce is not less than specified nonce, and records the last element
+// before it of each level into update if update is not nil.
+func (l *TxList) seek(nonce uint64, update []*TxElement) *TxElement {
+	x := &l.head
+	for i := l.level - 1; i >= 0; i-- {
+		for x.next[i] != nil && x.next[i].nonce < nonce {
+			x = x.next[i]
+		}
+		if update != nil {
+			update[i] = x
+		}
+	}
+	return x.next[0]
+}
+
+// unlink removes element e from list, update holds the last element before e of each level.
+func (l *TxList) unlink(e *TxElement, update []*TxElement) {
+	for i := range e.next {
+		update[i].next[i] = e.next[i]
+	}
+	if e.next[0] != nil {
+		e.next[0].prev = e.prev
+	} else {
+		l.tail = e.prev
+	}
+	for l.level > 1 && l.head.next[l.level-1] == nil {
+		l.level--
+	}
+	l.len--
+}
+
+// randomTxListLevel returns a random level for new element, each level is promoted with probability 1/4.
+func randomTxListLevel() int {
+	level := 1
+	for level < maxTxListLevel && rand.Intn(4) == 0 {
+		level++
+	}
+	return level
+}
@@ -0,0 +1,82 @@
+package tools
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"math/rand"
+	"testing"
+)
+
+func mockTimedTx(nonce uint64, price int64) *TimedTransaction {
+	tx := mockTransaction()
+	tx.Data.AccountNonce = nonce
+	tx.Data.Price = big.NewInt(price)
+	return &TimedTransaction{Tx: tx}
+}
+
+func TestTxList(t *testing.T) {
+	assert := assert.New(t)
+	l := NewTxList()
+	assert.Equal(0, l.Len())
+	assert.Nil(l.Front())
+	assert.Nil(l.Back())
+	assert.Nil(l.Get(0))
+	assert.Nil(l.Remove(0))
+
+	// insert in random order
+	const num = 5000
+	for _, i := range rand.Perm(num) {
+		assert.Nil(l.Put(mockTimedTx(uint64(i), 1)))
+	}
+	assert.Equal(num, l.Len())
+	nonce := uint64(0)
+	for e := l.Front(); e != nil; e = e.Next() {
+		assert.Equal(nonce, e.Value.Tx.Data.AccountNonce)
+		nonce++
+	}
+	assert.Equal(uint64(num), nonce)
+	for e := l.Back(); e != nil; e = e.Prev() {
+		nonce--
+		assert.Equal(nonce, e.Value.Tx.Data.AccountNonce)
+	}
+	assert.Equal(uint64(0), nonce)
+
+	// replace
+	replacing := mockTimedTx(10, 2)
+	assert.Equal(int64(1), l.Put(replacing).Tx.Data.Price.Int64())
+	assert.Equal(replacing, l.Get(10))
+	assert.Equal(num, l.Len())
+
+	// remove
+	assert.Equal(replacing, l.Remove(10))
+	assert.Nil(l.Get(10))
+	assert.Nil(l.Remove(10))
+	assert.Equal(num-1, l.Len())
+	assert.Equal(uint64(num-1), l.Remove(num-1).Tx.Data.AccountNonce)
+	assert.Equal(uint64(num-2), l.Back().Value.Tx.Data.AccountNonce)
+	assert.Nil(l.Back().Next())
+
+	// contiguous txs stop at the gap
+	assert.Len(l.Ready(0), 10)
+	assert.Len(l.Ready(11), num-12)
+	assert.Len(l.Ready(10), 0)
+
+	// remove older txs
+	removed := l.RemoveTo(20)
+	assert.Len(removed, 20)
+	for i, timedTx := range removed[:10] {
+		assert.Equal(uint64(i), timedTx.Tx.Data.AccountNonce)
+	}
+	assert.Equal(uint64(21), l.Front().Value.Tx.Data.AccountNonce)
+	assert.Nil(l.Front().Prev())
+	assert.Equal(num-22, l.Len())
+	assert.Len(l.RemoveTo(num), num-22)
+	assert.Equal(0, l.Len())
+	assert.Nil(l.Front())
+	assert.Nil(l.Back())
+
+	// reusable after being emptied
+	assert.Nil(l.Put(mockTimedTx(3, 1)))
+	assert.Equal(l.Front(), l.Back())
+	assert.Equal(l.Get(3), l.Back().Value)
+}
@@ -0,0 +1,106 @@
+package tools
+
+import (
+	"bytes"
+	"container/heap"
+	"github.com/DSiSc/craft/types"
+	"github.com/DSiSc/txpool/common"
+)
+
+// priceHeap is a heap of the head transactions of accounts, the transaction with higher price will be popped
+// first, transactions with same price are ordered by the time added to buffer.
+type priceHeap []*TimedTransaction
+
+func (h priceHeap) Len() int      { return len(h) }
+func (h priceHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h priceHeap) Less(i, j int) bool {
+	if cmp := common.TxPrice(h[i].Tx).Cmp(common.TxPrice(h[j].Tx)); cmp != 0 {
+		return cmp > 0
+	}
+	if !h[i].TimeStamp.Equal(h[j].TimeStamp) {
+		return h[i].TimeStamp.Before(h[j].TimeStamp)
+	}
+	return bytes.Compare(h[i].Tx.Data.From[:], h[j].Tx.Data.From[:]) < 0
+}
+
+func (h *priceHeap) Push(x interface{}) {
+	*h = append(*h, x.(*TimedTransaction))
+}
+
+func (h *priceHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	old[n-1] = nil
+	*h = old[0 : n-1]
+	return x
+}
+
+// cheapHeap is a heap of transactions, the transaction with lower price will be popped first, transactions with
+// same price are ordered by the time added to buffer, the newer first.
+type cheapHeap struct {
+	priceHeap
+}
+
+func (h cheapHeap) Less(i, j int) bool { return h.priceHeap.Less(j, i) }
+
+// TxsByPriceAndNonce represents a set of transactions that can return transactions in a price sorted order,
+// while keeping the nonce order of the transactions from same account.
+type TxsByPriceAndNonce struct {
+	txs   map[types.Address][]*TimedTransaction
+	heads priceHeap
+}
+
+// NewTxsByPriceAndNonce creates a transaction set that can retrieve price sorted transactions in a nonce-honouring
+// way. txs must be sorted by nonce for each account, and the map will be modified by the set.
+func NewTxsByPriceAndNonce(txs map[types.Address][]*TimedTransaction) *TxsByPriceAndNonce {
+	heads := make(priceHeap, 0, len(txs))
+	for from, accTxs := range txs {
+		if len(accTxs) == 0 {
+			delete(txs, from)
+			continue
+		}
+		heads = append(heads, accTxs[0])
+		txs[from] = accTxs[1:]
+	}
+	heap.Init(&heads)
+	return &TxsByPriceAndNonce{
+		txs:   txs,
+		heads: heads,
+	}
+}
+
+// Peek returns the next transaction by price, nil will be returned if there is no transaction left.
+func (self *TxsByPriceAndNonce) Peek() *types.Transaction {
+	if len(self.heads) == 0 {
+		return nil
+	}
+	return self.heads[0].Tx
+}
+
+// Shift replaces the current best head with the next one from the same account.
+func (self *TxsByPriceAndNonce) Shift() {
+	if len(self.heads) == 0 {
+		return
+	}
+	from := *self.heads[0].Tx.Data.From
+	if txs := self.txs[from]; len(txs) > 0 {
+		self.heads[0], self.txs[from] = txs[0], txs[1:]
+		heap.Fix(&self.heads, 0)
+		return
+	}
+	delete(self.txs, from)
+	heap.Pop(&self.heads)
+}
+
+// Pop removes the best transaction, *not* replacing it with the next one from the same account. This should be
+// used when a transaction cannot be executed and hence all subsequent ones should be discarded from the same
+// account.
+func (self *TxsByPriceAndNonce) Pop() {
+	if len(self.heads) == 0 {
+		return
+	}
+	delete(self.txs, *self.heads[0].Tx.Data.From)
+	heap.Pop(&self.heads)
+}
@@ -7,6 +7,8 @@
 package txpool
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/DSiSc/craft/log"
 	"github.com/DSiSc/craft/monitor"
@@ -14,43 +16,198 @@ import (
 	"github.com/DSiSc/repository"
 	"github.com/DSiSc/txpool/common"
 	"github.com/DSiSc/txpool/tools"
+	"math/big"
 	"sync"
+	"time"
 )
 
 type TxsPool interface {
-	// AddTx add a transaction to the txpool.
+	// AddTx add a transaction to the txpool, the transaction is treated as remote unless its sender is local.
 	AddTx(tx *types.Transaction) error
 
+	// AddLocalTx add a transaction submitted locally to the txpool, the sender will be marked as local account, whose
+	// transactions are exempt from the price limit, timeout and eviction, and are journaled to disk.
+	AddLocalTx(tx *types.Transaction) error
+
+	// AddRemoteTx add a transaction received from the network to the txpool.
+	AddRemoteTx(tx *types.Transaction) error
+
+	// AddTxs add a batch of remote transactions to the txpool, the error of each transaction is returned in order.
+	// One EventAddTxToTxPool is notified for the batch with the accepted transactions as TxsBatch.
+	AddTxs(txs []*types.Transaction) []error
+
+	// Reset moves the txpool from the old head block to the new one, the transactions in the blocks of old chain but
+	// not in the new chain are added back to the txpool.
+	Reset(oldHead, newHead *types.Block)
+
+	// SubscribeNewTxs subscribes the lifecycle events of transactions in txpool, the events are delivered to ch in
+	// order until the subscription is unsubscribed or the txpool is stopped. The events are dropped if ch falls too
+	// far behind.
+	SubscribeNewTxs(ch chan<- NewTxsEvent) Subscription
+
 	// DelTxs delete the transactions which in processing queue.
 	// Once a block was committed, transaction contained in the block can be removed.
 	DelTxs(txs []*types.Transaction)
 
 	// GetTxs gets the transactions which in pending status.
 	GetTxs() []*types.Transaction
+
+	// GetTxsWithLimits gets the transactions which in pending status, until the count, cumulative gas limit or
+	// cumulative RLP encoded size of the transactions reaches the limit. Zero limit means no limit.
+	GetTxsWithLimits(maxCount, maxGas, maxBytes uint64) []*types.Transaction
+
+	// Snapshot gets an immutable snapshot of the executable pending transactions, which is not affected by the
+	// later modification of the txpool.
+	Snapshot() *PendingSnapshot
+
+	// GetTxByHash gets the transaction in pending or queue by hash, nil will be returned if not exist.
+	GetTxByHash(hash types.Hash) *types.Transaction
+
+	// GetPoolNonce gets the highest nonce of the account's transactions in txpool.
+	GetPoolNonce(address types.Address) uint64
+
+	// Get gets the transaction in pending or queue by hash, nil will be returned if not exist.
+	Get(hash types.Hash) *types.Transaction
+
+	// Has checks whether the transaction is in pending or queue.
+	Has(hash types.Hash) bool
+
+	// Pending gets the pending transactions grouped by account and sorted by nonce.
+	Pending() map[types.Address][]*types.Transaction
+
+	// Queued gets the queued transactions grouped by account and sorted by nonce.
+	Queued() map[types.Address][]*types.Transaction
+
+	// ContentFrom gets the pending and queued transactions of the account, sorted by nonce.
+	ContentFrom(address types.Address) (pending, queued []*types.Transaction)
+
+	// Stats gets the number of pending and queued transactions.
+	Stats() (pending, queued int)
+
+	// Status gets the lifecycle status of the transaction, the status of the removed transactions is only kept for
+	// the recently removed ones.
+	Status(hash types.Hash) TxStatus
+
+	// Nonce gets the next usable nonce of the account, which is the chain nonce plus the number of the contiguous
+	// transactions in txpool.
+	Nonce(address types.Address) uint64
+
+	// Start starts the background workers of the txpool, the txpool will be stopped once ctx is done. Start is
+	// optional, a txpool that is not started still follows the chain, but doesn't remove the expired transactions.
+	Start(ctx context.Context) error
+
+	// Stop unsubscribes the chain events, stops the background workers and flushes the journal of the txpool, the
+	// transactions can't be added to the txpool any more.
+	Stop()
+
+	// Close stops the txpool, returns the error of flushing the journal if any.
+	Close() error
 }
 
+// TxsBatch is the payload of EventAddTxToTxPool aggregating the transactions accepted in a batch, as notified by
+// AddTxs and Reset. The transactions added one by one are notified as *types.Transaction.
+type TxsBatch []*types.Transaction
+
+// TxPool is the default implementation of TxsPool. The txs of each account are guarded by the lock of the shard
+// which the account is distributed to, so operations on a single account only hold the read lock of mu along with
+// the account's shard lock, and the txs of different accounts are added in parallel. Operations across accounts,
+// such as block commit, expiration and eviction of a full pool, hold the write lock of mu, while reading all the
+// accounts holds the read lock of mu along with the locks of all shards to get a consistent snapshot.
 type TxPool struct {
 	config      TxPoolConfig
-	txBuffer    *tools.ListBuffer
+	pending     *tools.ListBuffer // processable transactions, contiguous from chain nonce
+	queue       *tools.ListBuffer // future transactions, having nonce gap with pending ones
 	chain       *repository.Repository
 	mu          sync.RWMutex
+	shards      [accountShards]sync.Mutex // Locks of the account shards
 	eventCenter types.EventCenter
+	validators  []TxValidator
+	locals      *tools.AccountSet // Set of local accounts, exempt from the price limit, timeout and eviction
+	journal     *txJournal        // Journal of local transactions to back up to disk
+	feed        *txsFeed          // Feed of the lifecycle events of transactions
+	history     *tools.LRU        // Status of the recently removed transactions
+	senders     *tools.LRU        // Senders recovered from the signatures of the recent transactions, keyed by hash
+	sizes       *tools.LRU        // Encoded sizes of the recent transactions, keyed by hash
+	events      []NewTxsEvent     // Events to be delivered once the lock is released
+	eventsMu    sync.Mutex        // Lock of events, as events of different accounts are emitted in parallel
+	head        uint64            // Number of chain head updates, to invalidate the pending snapshot
+	snapshot    *PendingSnapshot  // Last snapshot of pending, shared until pending or chain head changes
+	snapshotMu  sync.Mutex        // Lock of snapshot
+
+	reorgMu      sync.Mutex
+	reorgRunning bool                       // Whether the reorg loop is running
+	reorgCh      chan struct{}              // Channel to wake up the reorg loop
+	reorgDone    chan struct{}              // Channel to be closed once the scheduled reorg is done
+	dirty        map[types.Address]struct{} // Accounts whose queued txs may be promoted by the reorg loop
+	headDirty    bool                       // Whether the chain head has changed since the last reorg
+
+	subscribers map[types.EventType]types.Subscriber // Subscribers of the chain events
+	started     bool                                 // Whether the pool has been started
+	closed      bool                                 // Whether the pool has been stopped
+	quit        chan struct{}                        // Channel to notify the background workers to exit
+	wg          sync.WaitGroup
 }
 
 // TxPoolConfig are the configuration parameters of the transaction pool.
 type TxPoolConfig struct {
 	GlobalSlots    uint64 // Maximum number of executable transaction slots for txpool
+	GlobalQueue    uint64 // Maximum number of non-executable transaction slots for txpool
+	AccountSlots   uint64 // Maximum number of executable transaction slots per account
+	AccountQueue   uint64 // Maximum number of non-executable transaction slots per account
 	MaxTrsPerBlock uint64 // Maximum num of transactions a block
 	TxMaxCacheTime uint64 // Maximum cache time(second) of transactions in tx pool
+	PriceBump      uint64 // Minimum price bump percentage(>0) to replace an already existing transaction (nonce)
+	PriceLimit     uint64 // Minimum gas price to enforce for acceptance of remote transactions
+	MaxTxSize      uint64 // Maximum RLP encoded size(byte) of a transaction
+	BlockGasLimit  uint64 // Maximum gas limit of a transaction, which can't exceed the block's, not limited if 0
+	IntrinsicGas   bool   // Whether to reject the transactions whose gas limit can't cover the intrinsic gas
+	Journal        string // Journal of local transactions to survive node restarts, journal is disabled if empty
+	Rejournal      uint64 // Time interval(second) to regenerate the transaction journal
+	ExpireInterval uint64 // Time interval(second) to remove the transactions exceeding the max cache time
+	StatusCache    uint64 // Maximum number of the recently removed transactions to keep status
+
+	Locals []types.Address // Addresses that should be treated by default as local
+
+	// Signer recovers and verifies the sender of transactions. It's nil by default, which disables the sender
+	// verification, and the From address of transactions is trusted as is.
+	Signer common.Signer
 }
 
 var DefaultTxPoolConfig = TxPoolConfig{
 	GlobalSlots:    40960,
+	GlobalQueue:    10240,
+	AccountSlots:   4096,
+	AccountQueue:   1024,
 	MaxTrsPerBlock: 20480,
 	TxMaxCacheTime: 600,
+	PriceBump:      10,
+	MaxTxSize:      32 * 1024,
+	Rejournal:      3600,
+	ExpireInterval: 60,
+	StatusCache:    10240,
 }
 
-var GlobalTxsPool *TxPool
+// accountShards is the number of account shards of txpool, the txs of accounts in different shards can be added
+// in parallel.
+const accountShards = 32
+
+// errExclusiveRequired is returned internally if adding tx has to modify the txs of other accounts, so the tx has
+// to be added within the write lock.
+var errExclusiveRequired = errors.New("exclusive lock is required")
+
+// senderCacheSize is the number of the recently recovered senders to cache.
+const senderCacheSize = 4096
+
+// sizeCacheSize is the number of the encoded sizes of recent transactions to cache.
+const sizeCacheSize = 4096
+
+// maxReorgDepth is the maximum number of blocks to walk back on each chain to reinject the transactions of reorg.
+const maxReorgDepth = 64
+
+var (
+	defaultTxsPool   TxsPool // Optional default txpool of the process, registered explicitly
+	defaultTxsPoolMu sync.RWMutex
+)
 
 // sanitize checks the provided user configurations and changes anything that's  unreasonable or unworkable.
 func (config *TxPoolConfig) sanitize() {
@@ -58,6 +215,18 @@ func (config *TxPoolConfig) sanitize() {
 		log.Warn("Sanitizing invalid txs pool global slots %d.", config.GlobalSlots)
 		config.GlobalSlots = DefaultTxPoolConfig.GlobalSlots
 	}
+	if config.GlobalQueue < 1 || config.GlobalQueue > DefaultTxPoolConfig.GlobalQueue {
+		log.Warn("Sanitizing invalid txs pool global queue %d.", config.GlobalQueue)
+		config.GlobalQueue = DefaultTxPoolConfig.GlobalQueue
+	}
+	if config.AccountSlots < 1 || config.AccountSlots > config.GlobalSlots {
+		log.Warn("Sanitizing invalid txs pool account slots %d.", config.AccountSlots)
+		config.AccountSlots = minUint64(DefaultTxPoolConfig.AccountSlots, config.GlobalSlots)
+	}
+	if config.AccountQueue < 1 || config.AccountQueue > config.GlobalQueue {
+		log.Warn("Sanitizing invalid txs pool account queue %d.", config.AccountQueue)
+		config.AccountQueue = minUint64(DefaultTxPoolConfig.AccountQueue, config.GlobalQueue)
+	}
 	if config.MaxTrsPerBlock < 1 || config.MaxTrsPerBlock > DefaultTxPoolConfig.MaxTrsPerBlock {
 		log.Warn("Sanitizing invalid txs pool max num of transactions a block %d.", config.MaxTrsPerBlock)
 		config.MaxTrsPerBlock = DefaultTxPoolConfig.MaxTrsPerBlock
@@ -66,112 +235,1138 @@ func (config *TxPoolConfig) sanitize() {
 		log.Warn("Sanitizing invalid txs pool max num cache time(%ds) of transactions in tx pool.", config.TxMaxCacheTime)
 		config.TxMaxCacheTime = DefaultTxPoolConfig.TxMaxCacheTime
 	}
+	if config.MaxTxSize < 1 {
+		log.Warn("Sanitizing invalid txs pool max tx size %d.", config.MaxTxSize)
+		config.MaxTxSize = DefaultTxPoolConfig.MaxTxSize
+	}
+	if config.Rejournal < 1 {
+		log.Warn("Sanitizing invalid txs pool journal time %ds.", config.Rejournal)
+		config.Rejournal = DefaultTxPoolConfig.Rejournal
+	}
+	if config.ExpireInterval < 1 {
+		log.Warn("Sanitizing invalid txs pool expire interval %ds.", config.ExpireInterval)
+		config.ExpireInterval = DefaultTxPoolConfig.ExpireInterval
+	}
+	if config.StatusCache < 1 {
+		log.Warn("Sanitizing invalid txs pool status cache size %d.", config.StatusCache)
+		config.StatusCache = DefaultTxPoolConfig.StatusCache
+	}
+	// zero price bump is treated as unset like the other limits, as replacing at equal price is never allowed
+	if config.PriceBump < 1 {
+		log.Warn("Sanitizing invalid txs pool price bump %d%%.", config.PriceBump)
+		config.PriceBump = DefaultTxPoolConfig.PriceBump
+	}
 }
 
 // NewTxPool creates a new transaction pool to gather, sort and filter inbound transactions from the network and local.
 func NewTxPool(config TxPoolConfig, eventCenter types.EventCenter) TxsPool {
 	config.sanitize()
 	// Create the transaction pool with its initial settings
+	quit := make(chan struct{})
 	pool := &TxPool{
 		config:      config,
-		txBuffer:    tools.NewListBuffer(config.GlobalSlots, config.TxMaxCacheTime),
+		pending:     tools.NewListBuffer(config.GlobalSlots, config.TxMaxCacheTime, config.PriceBump),
+		queue:       tools.NewListBuffer(config.GlobalQueue, config.TxMaxCacheTime, config.PriceBump),
 		eventCenter: eventCenter,
+		validators:  defaultValidators(config),
+		locals:      tools.NewAccountSet(config.Locals...),
+		feed:        newTxsFeed(quit),
+		history:     tools.NewLRU(int(config.StatusCache)),
+		senders:     tools.NewLRU(senderCacheSize),
+		sizes:       tools.NewLRU(sizeCacheSize),
+		quit:        quit,
+		reorgCh:     make(chan struct{}, 1),
+		dirty:       make(map[types.Address]struct{}),
+	}
+	pool.pending.SetLocals(pool.locals)
+	pool.queue.SetLocals(pool.locals)
+	if config.Signer == nil {
+		log.Warn("No signer is configured, the sender of transactions will not be verified.")
 	}
-	GlobalTxsPool = pool
 
-	// subscribe block commit event
-	pool.eventCenter.Subscribe(types.EventBlockCommitted, pool.updateChainInstance)
-	pool.eventCenter.Subscribe(types.EventBlockWritten, pool.updateChainInstance)
+	// load local transactions from the journal, and regenerate the journal with the valid ones
+	if config.Journal != "" {
+		journal := newTxJournal(config.Journal)
+		if err := journal.load(pool.AddLocalTx); err != nil {
+			log.Warn("Failed to load transaction journal, as: %v", err)
+		}
+		pool.journal = journal
+		pool.rotateJournal()
+	}
 
+	// subscribe block commit event
+	pool.subscribers = map[types.EventType]types.Subscriber{
+		types.EventBlockCommitted: pool.eventCenter.Subscribe(types.EventBlockCommitted, pool.updateChainInstance),
+		types.EventBlockWritten:   pool.eventCenter.Subscribe(types.EventBlockWritten, pool.updateChainInstance),
+	}
 	return pool
 }
 
-// Get pending txs from txpool.
+// defaultValidators returns the validation chain enabled by config, more validators can be appended by
+// RegisterValidator.
+func defaultValidators(config TxPoolConfig) []TxValidator {
+	validators := []TxValidator{NewAmountValidator(), NewTxSizeValidator(config.MaxTxSize)}
+	if config.BlockGasLimit > 0 {
+		validators = append(validators, NewGasLimitValidator(config.BlockGasLimit))
+	}
+	if config.IntrinsicGas {
+		validators = append(validators, NewIntrinsicGasValidator())
+	}
+	return append(validators, NewBalanceValidator())
+}
+
+// Start starts the janitor and the reorg loop of txpool, txpool will be stopped once ctx is done. Starting a started
+// txpool has no effect, ErrPoolClosed will be returned if txpool has been stopped.
+func (pool *TxPool) Start(ctx context.Context) error {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	if pool.closed {
+		return ErrPoolClosed
+	}
+	if pool.started {
+		return nil
+	}
+	pool.started = true
+
+	// start the janitor and the reorg loop of the pool
+	pool.reorgMu.Lock()
+	pool.reorgRunning = true
+	pool.reorgMu.Unlock()
+	pool.wg.Add(2)
+	go pool.loop()
+	go pool.reorgLoop()
+	go func() {
+		select {
+		case <-ctx.Done():
+			pool.Stop()
+		case <-pool.quit:
+		}
+	}()
+	return nil
+}
+
+// Stop stops txpool, it's safe to call Stop multiple times.
+func (pool *TxPool) Stop() {
+	if err := pool.Close(); err != nil {
+		log.Warn("Failed to close transaction journal, as: %v", err)
+	}
+}
+
+// Close unsubscribes the block commit events, stops the janitor and closes the journal of txpool. Closing a closed
+// txpool has no effect.
+func (pool *TxPool) Close() error {
+	pool.mu.Lock()
+	if pool.closed {
+		pool.mu.Unlock()
+		return nil
+	}
+	pool.closed = true
+	subscribers := pool.subscribers
+	pool.subscribers = nil
+	close(pool.quit)
+	pool.mu.Unlock()
+
+	// event handler may be waiting for the lock, so unsubscribe events and wait workers without lock
+	for eventType, subscriber := range subscribers {
+		if err := pool.eventCenter.UnSubscribe(eventType, subscriber); err != nil {
+			log.Warn("Failed to unsubscribe event %v, as: %v", eventType, err)
+		}
+	}
+	pool.wg.Wait()
+
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	if pool.journal != nil {
+		return pool.journal.close()
+	}
+	return nil
+}
+
+// loop is the janitor of txpool, which removes the expired transactions and regenerates the journal periodically.
+func (pool *TxPool) loop() {
+	defer pool.wg.Done()
+	expire := time.NewTicker(time.Duration(pool.config.ExpireInterval) * time.Second)
+	defer expire.Stop()
+	rejournal := time.NewTicker(time.Duration(pool.config.Rejournal) * time.Second)
+	defer rejournal.Stop()
+	for {
+		select {
+		case <-expire.C:
+			pool.mu.Lock()
+			pool.removeExpired()
+			pool.mu.Unlock()
+			pool.flushEvents()
+		case <-rejournal.C:
+			pool.mu.Lock()
+			if pool.journal != nil {
+				pool.rotateJournal()
+			}
+			pool.mu.Unlock()
+		case <-pool.quit:
+			return
+		}
+	}
+}
+
+// reorgLoop is the only worker to reconcile txpool in background once txpool is started. It batches the dirty
+// accounts and chain head changes scheduled since the last run, then promotes the executable queued txs, demotes
+// the invalidated pending ones and enforces the limits of txpool in one pass.
+func (pool *TxPool) reorgLoop() {
+	defer pool.wg.Done()
+	for {
+		select {
+		case <-pool.reorgCh:
+			pool.reorgMu.Lock()
+			dirty, reset, done := pool.dirty, pool.headDirty, pool.reorgDone
+			pool.dirty, pool.headDirty, pool.reorgDone = make(map[types.Address]struct{}), false, nil
+			pool.reorgMu.Unlock()
+			pool.runReorg(dirty, reset)
+			if done != nil {
+				close(done)
+			}
+		case <-pool.quit:
+			pool.reorgMu.Lock()
+			pool.reorgRunning = false
+			if pool.reorgDone != nil {
+				close(pool.reorgDone)
+				pool.reorgDone = nil
+			}
+			pool.reorgMu.Unlock()
+			return
+		}
+	}
+}
+
+// scheduleReorg schedules the reorg loop to promote the queued txs of dirty accounts, and to reset txpool to the
+// latest chain head if reset is true. The returned channel is closed once the scheduled reorg is done, nil will be
+// returned if the reorg loop is not running, then caller has to reconcile txpool by itself.
+func (pool *TxPool) scheduleReorg(reset bool, dirty ...types.Address) <-chan struct{} {
+	pool.reorgMu.Lock()
+	defer pool.reorgMu.Unlock()
+	if !pool.reorgRunning {
+		return nil
+	}
+	for _, address := range dirty {
+		pool.dirty[address] = struct{}{}
+	}
+	pool.headDirty = pool.headDirty || reset
+	if pool.reorgDone == nil {
+		pool.reorgDone = make(chan struct{})
+	}
+	select {
+	case pool.reorgCh <- struct{}{}:
+	default:
+	}
+	return pool.reorgDone
+}
+
+// runReorg resets txpool to the latest chain head if reset is true, otherwise promotes the queued txs of dirty
+// accounts, then enforces the limits of pending and queue.
+func (pool *TxPool) runReorg(dirty map[types.Address]struct{}, reset bool) {
+	pool.mu.Lock()
+	if pool.closed {
+		pool.mu.Unlock()
+		return
+	}
+	if reset {
+		pool.updateChainInstanceWithoutLock()
+		pool.reset()
+	} else {
+		for address := range dirty {
+			pool.promote(address, pool.pendingNonce(address, pool.getChainNonce(address)))
+		}
+	}
+	pool.truncate(pool.pending)
+	pool.truncate(pool.queue)
+	pool.mu.Unlock()
+	pool.flushEvents()
+}
+
+// Get pending txs from txpool, txs with higher price will be returned first.
 func (pool *TxPool) GetTxs() []*types.Transaction {
-	txList := make([]*types.Transaction, 0)
+	return pool.GetTxsWithLimits(pool.config.MaxTrsPerBlock, 0, 0)
+}
+
+// Get pending txs from txpool by price until reaching the specified limits. Tx exceeding the left gas or size
+// budget will be skipped along with the subsequent txs of the same account, to keep the nonce continuity. It never
+// modifies txpool, stale txs already executed by chain are skipped, and pruned on block commit.
+func (pool *TxPool) GetTxsWithLimits(maxCount, maxGas, maxBytes uint64) []*types.Transaction {
+	pool.chainInstance()
 	pool.mu.RLock()
-	defer pool.mu.RUnlock()
-	log.Debug("total number of tx in pool is: %d", pool.txBuffer.Len())
-	for addr, l := range pool.txBuffer.TimedTxGroups() {
-		startNonce := pool.getChainNonce(addr)
-		log.Debug("account %x chain nonce %d VS %d", addr, startNonce, pool.txBuffer.NonceInBuffer(addr))
-		for elem := l.Front(); elem != nil; {
-			nextElem := elem.Next()
-			timedTx := elem.Value.(*tools.TimedTransaction)
-			if timedTx.Tx.Data.AccountNonce == startNonce {
-				txList = append(txList, timedTx.Tx)
-				startNonce++
-				if uint64(len(txList)) >= pool.config.MaxTrsPerBlock {
-					monitor.JTMetrics.TxpoolOutgoingTx.Add(float64(len(txList)))
-					return txList
-				}
-			} else if timedTx.Tx.Data.AccountNonce < startNonce {
-				pool.txBuffer.RemoveTx(timedTx.Tx.Hash.Load().(types.Hash))
-			}
-			elem = nextElem
+	snapshot := pool.pendingSnapshot()
+	pool.mu.RUnlock()
+
+	txList := make([]*types.Transaction, 0)
+	var gas, size uint64
+	sortedTxs := snapshot.Iterator()
+	for tx := sortedTxs.Peek(); tx != nil; tx = sortedTxs.Peek() {
+		if maxCount > 0 && uint64(len(txList)) >= maxCount {
+			break
 		}
+		txSize := pool.txSize(tx)
+		if (maxGas > 0 && tx.Data.GasLimit > maxGas-gas) || (maxBytes > 0 && txSize > maxBytes-size) {
+			log.Debug("skip account %x, as tx %x exceeds the block limits", *tx.Data.From, common.TxHash(tx))
+			sortedTxs.Pop()
+			continue
+		}
+		gas += tx.Data.GasLimit
+		size += txSize
+		txList = append(txList, tx)
+		sortedTxs.Shift()
 	}
 	monitor.JTMetrics.TxpoolOutgoingTx.Add(float64(len(txList)))
 	return txList
 }
 
+// Snapshot returns an immutable snapshot of the executable pending txs. Snapshot is copy-on-write, the last
+// snapshot is shared by the callers until pending or the chain head changes.
+func (pool *TxPool) Snapshot() *PendingSnapshot {
+	pool.chainInstance()
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+	pool.snapshotMu.Lock()
+	defer pool.snapshotMu.Unlock()
+	if cached := pool.snapshot; cached != nil && cached.version == pool.pending.Version() && cached.head == pool.head {
+		return cached
+	}
+	pool.snapshot = pool.pendingSnapshot()
+	return pool.snapshot
+}
+
+// pendingSnapshot takes a snapshot of the executable pending txs, which are contiguous from the chain nonce. The
+// read lock must be held by caller.
+func (pool *TxPool) pendingSnapshot() *PendingSnapshot {
+	pool.lockShards()
+	defer pool.unlockShards()
+	log.Debug("total number of tx in pool is: %d, pending: %d", pool.len(), pool.pending.Len())
+	executables := make(map[types.Address][]*tools.TimedTransaction)
+	for _, addr := range pool.pending.Accounts() {
+		startNonce := pool.chain.GetNonce(addr)
+		log.Debug("account %x chain nonce %d VS %d", addr, startNonce, pool.pending.NonceInBuffer(addr))
+		if ready := pool.pending.TxGroup(addr).Ready(startNonce); len(ready) > 0 {
+			executables[addr] = ready
+		}
+	}
+	return newPendingSnapshot(executables, pool.pending.Version(), pool.head)
+}
+
+// RegisterValidator appends validators to the validation chain of txpool, transactions will be rejected by txpool
+// if any validator fails.
+func (pool *TxPool) RegisterValidator(validators ...TxValidator) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	pool.validators = append(pool.validators, validators...)
+}
+
 // Update processing queue, clean txs from process and all queue.
 func (pool *TxPool) DelTxs(txs []*types.Transaction) {
 	pool.mu.Lock()
-	defer pool.mu.Unlock()
+	if pool.closed {
+		pool.mu.Unlock()
+		return
+	}
 	for _, tx := range txs {
-		pool.txBuffer.RemoveOlderTx(*tx.Data.From, tx.Data.AccountNonce)
+		from := *tx.Data.From
+		pool.discard(TxsDropped, TxStatusIncluded, pool.pending.RemoveOlderTx(from, tx.Data.AccountNonce)...)
+		pool.discard(TxsDropped, TxStatusIncluded, pool.queue.RemoveOlderTx(from, tx.Data.AccountNonce)...)
+		pool.promote(from, pool.pendingNonce(from, tx.Data.AccountNonce+1))
 	}
+	// the promoted txs may exceed the limit of pending
+	pool.truncate(pool.pending)
+	pool.truncate(pool.queue)
+	pool.mu.Unlock()
+	pool.flushEvents()
 }
 
 // Adding transaction to the txpool
 func (pool *TxPool) AddTx(tx *types.Transaction) error {
-	hash := common.TxHash(tx)
-	monitor.JTMetrics.TxpoolIngressTx.Add(float64(1))
-	pool.mu.Lock()
-	chainNonce := pool.getChainNonce(*tx.Data.From)
-	if tx.Data.AccountNonce < chainNonce {
+	return pool.addTx(tx, false)
+}
+
+// Adding local transaction to the txpool
+func (pool *TxPool) AddLocalTx(tx *types.Transaction) error {
+	return pool.addTx(tx, true)
+}
+
+// Adding remote transaction to the txpool
+func (pool *TxPool) AddRemoteTx(tx *types.Transaction) error {
+	return pool.addTx(tx, false)
+}
+
+// Adding a batch of remote transactions to the txpool, the error of each transaction is returned in the same order,
+// nil for the accepted ones.
+func (pool *TxPool) AddTxs(txs []*types.Transaction) []error {
+	added, errs := pool.addTxs(txs, false)
+	pool.notifyBatch(added)
+	return errs
+}
+
+// addTx validates tx and adds it to pending or queue, the sender of tx will be marked as local if local is true.
+func (pool *TxPool) addTx(tx *types.Transaction, local bool) error {
+	added, errs := pool.addTxs([]*types.Transaction{tx}, local)
+	if len(added) > 0 {
+		pool.eventCenter.Notify(types.EventAddTxToTxPool, tx)
+	}
+	return errs[0]
+}
+
+// notifyBatch notifies one aggregated event of adding txs to txpool for the accepted txs of a batch.
+func (pool *TxPool) notifyBatch(added []*types.Transaction) {
+	if len(added) > 0 {
+		pool.eventCenter.Notify(types.EventAddTxToTxPool, TxsBatch(added))
+	}
+}
+
+// addTxs validates txs outside the lock, then adds the valid ones to pending or queue within the read lock and the
+// lock of each tx's account shard, the chain nonce of each account is only fetched once. The txs which have to evict
+// the txs of other accounts are added within the write lock at last, along with truncating pending and queue. The
+// accepted txs and the error of each tx are returned.
+func (pool *TxPool) addTxs(txs []*types.Transaction, local bool) ([]*types.Transaction, []error) {
+	errs := make([]error, len(txs))
+	chain, validators := pool.validationContext()
+	for i, tx := range txs {
+		monitor.JTMetrics.TxpoolIngressTx.Add(float64(1))
+		if err := pool.validateTx(tx, chain, validators); err != nil {
+			errs[i] = newTxError(common.TxHash(tx), err)
+		}
+	}
+
+	added := make([]*types.Transaction, 0, len(txs))
+	chainNonces := make(map[types.Address]uint64)
+	exclusive := make([]int, 0)
+	pool.mu.RLock()
+	for i, tx := range txs {
+		if errs[i] != nil {
+			continue
+		}
+		if pool.closed {
+			errs[i] = newTxError(common.TxHash(tx), ErrPoolClosed)
+			continue
+		}
+		lock := pool.accountLock(*tx.Data.From)
+		lock.Lock()
+		chainNonce, ok := chainNonces[*tx.Data.From]
+		if !ok {
+			chainNonce = chain.GetNonce(*tx.Data.From)
+			chainNonces[*tx.Data.From] = chainNonce
+		}
+		err := pool.add(tx, chainNonce, local, false)
+		lock.Unlock()
+		if err == errExclusiveRequired {
+			exclusive = append(exclusive, i)
+		} else if err != nil {
+			errs[i] = newTxError(common.TxHash(tx), err)
+		} else {
+			added = append(added, tx)
+		}
+	}
+	pool.mu.RUnlock()
+
+	// the limits may be exceeded by the txs added in parallel, the cheapest ones are discarded within the write lock
+	if len(exclusive) > 0 || pool.pending.IsOverflowed() || pool.queue.IsOverflowed() {
+		pool.mu.Lock()
+		for _, i := range exclusive {
+			tx := txs[i]
+			if pool.closed {
+				errs[i] = newTxError(common.TxHash(tx), ErrPoolClosed)
+				continue
+			}
+			if err := pool.add(tx, chainNonces[*tx.Data.From], local, true); err != nil {
+				errs[i] = newTxError(common.TxHash(tx), err)
+				continue
+			}
+			added = append(added, tx)
+		}
+		pool.truncate(pool.pending)
+		pool.truncate(pool.queue)
 		pool.mu.Unlock()
-		return fmt.Errorf("Tx %x nonce is too low", hash)
 	}
+	log.Debug("tx num in pool: %d", pool.len())
+	pool.flushEvents()
+	return added, errs
+}
 
-	preLen := pool.txBuffer.Len()
-	if err := pool.txBuffer.AddTx(tx); err != nil {
+// validationContext returns the chain instance and validators to validate txs outside the lock.
+func (pool *TxPool) validationContext() (*repository.Repository, []TxValidator) {
+	chain := pool.chainInstance()
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+	return chain, pool.validators
+}
+
+// chainInstance returns the chain instance, which is initialized within the write lock if needed.
+func (pool *TxPool) chainInstance() *repository.Repository {
+	pool.mu.RLock()
+	chain := pool.chain
+	pool.mu.RUnlock()
+	if chain == nil {
+		pool.mu.Lock()
+		if nil == pool.chain {
+			pool.updateChainInstanceWithoutLock()
+		}
+		chain = pool.chain
 		pool.mu.Unlock()
+	}
+	return chain
+}
+
+// validateTx checks the sender of tx, and validates tx by validators on the specified chain state.
+func (pool *TxPool) validateTx(tx *types.Transaction, chain *repository.Repository, validators []TxValidator) error {
+	hash := common.TxHash(tx)
+	if err := pool.verifySender(tx); err != nil {
+		log.Debug("Failed to verify the sender of tx %x, as: %v", hash, err)
+		return err
+	}
+	for _, validator := range validators {
+		if err := validator.Validate(tx, chain); err != nil {
+			log.Debug("Tx %x is invalid, as: %v", hash, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// add adds the validated tx to pending or queue according to chain nonce. Either the write lock, or the read lock
+// along with the lock of tx's account shard must be held by caller, as indicated by exclusive. errExclusiveRequired
+// will be returned without any modification if the txs of other accounts have to be evicted without the write lock.
+func (pool *TxPool) add(tx *types.Transaction, chainNonce uint64, local bool, exclusive bool) error {
+	hash := common.TxHash(tx)
+	if tx.Data.AccountNonce < chainNonce {
+		return ErrNonceTooLow
+	}
+	if pool.pending.GetTx(hash) != nil || pool.queue.GetTx(hash) != nil {
+		monitor.JTMetrics.TxpoolDuplacatedTx.Add(float64(1))
+		log.Debug("The tx %x has exist, please confirm.", hash)
+		return ErrAlreadyKnown
+	}
+	if local {
+		pool.locals.Add(*tx.Data.From)
+	}
+	local = pool.locals.ContainsTx(tx)
+	if !local && common.TxPrice(tx).Cmp(new(big.Int).SetUint64(pool.config.PriceLimit)) < 0 {
+		log.Debug("Tx %x is underpriced, as price limit is %d.", hash, pool.config.PriceLimit)
+		return ErrUnderpriced
+	}
+	if !pool.affordable(tx, chainNonce) {
+		log.Debug("Account %x can't afford tx %x along with its preceding pending txs.", *tx.Data.From, hash)
+		return ErrInsufficientFunds
+	}
+
+	// executable transactions go to pending, future ones and the ones exceeding account slots wait in queue
+	nextNonce := pool.pendingNonce(*tx.Data.From, chainNonce)
+	buffer := pool.queue
+	if tx.Data.AccountNonce < nextNonce || (tx.Data.AccountNonce == nextNonce &&
+		uint64(pool.pending.AccountLen(*tx.Data.From)) < pool.config.AccountSlots &&
+		pool.queue.GetTxByNonce(*tx.Data.From, tx.Data.AccountNonce) == nil) {
+		buffer = pool.pending
+	}
+	// evicting the txs of other accounts requires the write lock
+	if !exclusive && buffer.IsFull() && buffer.GetTxByNonce(*tx.Data.From, tx.Data.AccountNonce) == nil {
+		return errExclusiveRequired
+	}
+	if buffer == pool.queue {
+		if err := pool.makeAccountRoom(tx); err != nil {
+			log.Debug("The queue of account %x is full, discard tx %x.", *tx.Data.From, hash)
+			monitor.JTMetrics.TxpoolDiscardedTx.Add(float64(1))
+			return err
+		}
+	}
+
+	// make room for the new tx by discarding the cheap ones
+	if exclusive && buffer.IsFull() && buffer.GetTxByNonce(*tx.Data.From, tx.Data.AccountNonce) == nil {
+		queued := buffer == pool.queue
+		var err error
+		if buffer, err = pool.evict(buffer, tx, local); err != nil {
+			log.Debug("Tx pool is full, discard underpriced tx %x.", hash)
+			monitor.JTMetrics.TxpoolDiscardedTx.Add(float64(1))
+			return err
+		}
+		// the evicted tx may be a preceding one of the same account
+		if nextNonce = pool.pendingNonce(*tx.Data.From, chainNonce); tx.Data.AccountNonce > nextNonce {
+			buffer = pool.queue
+		}
+		// the account's queue is checked again if tx is switched to queue
+		if buffer == pool.queue && !queued {
+			if err := pool.makeAccountRoom(tx); err != nil {
+				log.Debug("The queue of account %x is full, discard tx %x.", *tx.Data.From, hash)
+				monitor.JTMetrics.TxpoolDiscardedTx.Add(float64(1))
+				return err
+			}
+		}
+	}
+
+	replaced := buffer.GetTxByNonce(*tx.Data.From, tx.Data.AccountNonce)
+	if err := buffer.AddTx(tx); err != nil {
 		if err == tools.DuplicateError {
 			monitor.JTMetrics.TxpoolDuplacatedTx.Add(float64(1))
 			log.Debug("The tx %x has exist, please confirm.", hash)
-			return fmt.Errorf("the tx %x has exist", hash)
-		} else {
-			return fmt.Errorf("Tx pool is full, will discard tx %x. ", hash)
+			return ErrAlreadyKnown
+		} else if err == tools.ReplaceUnderpricedError {
+			log.Debug("The tx %x is underpriced to replace the exist one.", hash)
+			return ErrReplaceUnderpriced
 		}
+		log.Debug("Failed to add tx %x, as: %v", hash, err)
+		return err
 	}
 
-	if pool.txBuffer.Len() <= preLen {
-		log.Error("Tx pool is full, have discard some tx.")
-		monitor.JTMetrics.TxpoolDiscardedTx.Add(float64(1))
-	} else {
+	if replaced == nil {
 		monitor.JTMetrics.TxpoolPooledTx.Add(float64(1))
+	} else {
+		pool.emit(TxsReplaced, replaced)
+		pool.history.Add(common.TxHash(replaced), TxStatus{Kind: TxStatusReplaced, ReplacedBy: hash, Time: time.Now()})
+	}
+	if buffer == pool.pending {
+		pool.emit(TxsPromoted, tx)
+	} else {
+		pool.emit(TxsQueued, tx)
+	}
+	// the queued txs following tx are promoted by the reorg loop if it's running
+	if buffer == pool.pending && tx.Data.AccountNonce == nextNonce && pool.queue.AccountLen(*tx.Data.From) > 0 {
+		if pool.scheduleReorg(false, *tx.Data.From) == nil {
+			pool.promote(*tx.Data.From, nextNonce+1)
+		}
+	}
+	if local {
+		pool.journalTx(tx)
 	}
-	pool.mu.Unlock()
-	log.Debug("tx num in pool: %d", pool.txBuffer.Len())
-	pool.eventCenter.Notify(types.EventAddTxToTxPool, tx)
 	return nil
 }
 
-func GetTxByHash(hash types.Hash) *types.Transaction {
-	GlobalTxsPool.mu.RLock()
-	defer GlobalTxsPool.mu.RUnlock()
-	if txElem := GlobalTxsPool.txBuffer.GetTx(hash); txElem != nil {
+// Get tx from pending or queue by hash
+func (pool *TxPool) GetTxByHash(hash types.Hash) *types.Transaction {
+	return pool.Get(hash)
+}
+
+// Get tx from pending or queue by hash
+func (pool *TxPool) Get(hash types.Hash) *types.Transaction {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+	if txElem := pool.pending.GetTx(hash); txElem != nil {
+		return txElem
+	}
+	if txElem := pool.queue.GetTx(hash); txElem != nil {
 		return txElem
 	}
 	return nil
 }
 
+// Check whether tx is in pending or queue
+func (pool *TxPool) Has(hash types.Hash) bool {
+	return pool.Get(hash) != nil
+}
+
+// Get pending txs grouped by account
+func (pool *TxPool) Pending() map[types.Address][]*types.Transaction {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+	pool.lockShards()
+	defer pool.unlockShards()
+	return groupedTxs(pool.pending)
+}
+
+// Get queued txs grouped by account
+func (pool *TxPool) Queued() map[types.Address][]*types.Transaction {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+	pool.lockShards()
+	defer pool.unlockShards()
+	return groupedTxs(pool.queue)
+}
+
+// Get pending and queued txs of account
+func (pool *TxPool) ContentFrom(address types.Address) ([]*types.Transaction, []*types.Transaction) {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+	lock := pool.accountLock(address)
+	lock.Lock()
+	defer lock.Unlock()
+	return accountTxs(pool.pending, address), accountTxs(pool.queue, address)
+}
+
+// Get the number of pending and queued txs
+func (pool *TxPool) Stats() (int, int) {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+	return pool.pending.Len(), pool.queue.Len()
+}
+
+// Get the lifecycle status of tx
+func (pool *TxPool) Status(hash types.Hash) TxStatus {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+	if pool.pending.GetTx(hash) != nil {
+		return TxStatus{Kind: TxStatusPending}
+	}
+	if pool.queue.GetTx(hash) != nil {
+		return TxStatus{Kind: TxStatusQueued}
+	}
+	if status, ok := pool.history.Get(hash); ok {
+		return status.(TxStatus)
+	}
+	return TxStatus{Kind: TxStatusUnknown}
+}
+
+// Get the next usable nonce of account, queued txs contiguous with pending ones are counted too.
+func (pool *TxPool) Nonce(address types.Address) uint64 {
+	chain := pool.chainInstance()
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+	lock := pool.accountLock(address)
+	lock.Lock()
+	defer lock.Unlock()
+	nonce := pool.pendingNonce(address, chain.GetNonce(address))
+	if queued := pool.queue.TxGroup(address); queued != nil {
+		nonce += uint64(len(queued.Ready(nonce)))
+	}
+	return nonce
+}
+
+// Get the highest nonce of account's txs in pool
+func (pool *TxPool) GetPoolNonce(address types.Address) uint64 {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+	lock := pool.accountLock(address)
+	lock.Lock()
+	defer lock.Unlock()
+	if pool.queue.TxGroup(address) != nil {
+		return pool.queue.NonceInBuffer(address)
+	}
+	return pool.pending.NonceInBuffer(address)
+}
+
+// RegisterDefaultTxPool registers pool as the default txpool of the process, which serves the package level
+// GetTxByHash and GetPoolNonce. Registering nil unregisters the default txpool.
+func RegisterDefaultTxPool(pool TxsPool) {
+	defaultTxsPoolMu.Lock()
+	defer defaultTxsPoolMu.Unlock()
+	defaultTxsPool = pool
+}
+
+// DefaultTxPool returns the registered default txpool, nil will be returned if no txpool is registered.
+func DefaultTxPool() TxsPool {
+	defaultTxsPoolMu.RLock()
+	defer defaultTxsPoolMu.RUnlock()
+	return defaultTxsPool
+}
+
+// GetTxByHash gets tx by hash from the default txpool, nil will be returned if no txpool is registered.
+//
+// Deprecated: use TxsPool.GetTxByHash instead.
+func GetTxByHash(hash types.Hash) *types.Transaction {
+	if pool := DefaultTxPool(); pool != nil {
+		return pool.GetTxByHash(hash)
+	}
+	return nil
+}
+
+// GetPoolNonce gets the highest nonce of account's txs from the default txpool, 0 will be returned if no txpool
+// is registered.
+//
+// Deprecated: use TxsPool.GetPoolNonce instead.
 func GetPoolNonce(address types.Address) uint64 {
-	GlobalTxsPool.mu.RLock()
-	defer GlobalTxsPool.mu.RUnlock()
-	return GlobalTxsPool.txBuffer.NonceInBuffer(address)
+	if pool := DefaultTxPool(); pool != nil {
+		return pool.GetPoolNonce(address)
+	}
+	return 0
+}
+
+// txSize returns the encoded size of tx, which is cached by tx hash, so the pending txs are not encoded again on each
+// block assembly.
+func (pool *TxPool) txSize(tx *types.Transaction) uint64 {
+	hash := common.TxHash(tx)
+	if cached, ok := pool.sizes.Get(hash); ok {
+		return cached.(uint64)
+	}
+	size := common.TxSize(tx)
+	pool.sizes.Add(hash, size)
+	return size
+}
+
+// verifySender checks that the sender recovered from the signature of tx is the From address of tx. The recovered
+// senders are cached by tx hash, so the resubmitted and reinjected txs are not recovered again.
+func (pool *TxPool) verifySender(tx *types.Transaction) error {
+	if pool.config.Signer == nil {
+		return nil
+	}
+	hash := common.TxHash(tx)
+	var from types.Address
+	if cached, ok := pool.senders.Get(hash); ok {
+		from = cached.(types.Address)
+	} else {
+		sender, err := common.Sender(pool.config.Signer, tx)
+		if err != nil {
+			return err
+		}
+		from = sender
+		pool.senders.Add(hash, from)
+	}
+	if tx.Data.From == nil || from != *tx.Data.From {
+		return ErrInvalidSender
+	}
+	return nil
+}
+
+// pendingNonce returns the next nonce to be appended to account's pending transactions, defaultNonce will be
+// returned if the account has no pending transactions.
+func (pool *TxPool) pendingNonce(address types.Address, defaultNonce uint64) uint64 {
+	if pool.pending.TxGroup(address) != nil {
+		return pool.pending.NonceInBuffer(address) + 1
+	}
+	return defaultNonce
+}
+
+// promote moves account's transactions from queue to pending, as long as they are contiguous with specified nonce.
+func (pool *TxPool) promote(address types.Address, nonce uint64) {
+	queued := pool.queue.TxGroup(address)
+	if queued == nil {
+		return
+	}
+	for _, timedTx := range queued.Ready(nonce) {
+		if uint64(pool.pending.AccountLen(address)) >= pool.config.AccountSlots {
+			return
+		}
+		hash := timedTx.Tx.Hash.Load().(types.Hash)
+		if err := pool.pending.AddTimedTx(timedTx); err != nil || pool.pending.GetTx(hash) == nil {
+			log.Debug("failed to promote tx %x, pending is full", hash)
+			return
+		}
+		pool.queue.RemoveTx(hash)
+		pool.emit(TxsPromoted, timedTx.Tx)
+	}
+}
+
+// demote moves account's pending transactions back to queue if they are no longer contiguous with chain nonce.
+func (pool *TxPool) demote(address types.Address, chainNonce uint64) {
+	pending := pool.pending.TxGroup(address)
+	if pending == nil || pending.Front().Value.Tx.Data.AccountNonce == chainNonce {
+		return
+	}
+	pool.demoteFrom(address, 0)
+}
+
+// demoteFrom moves account's pending transactions whose nonce is not less than specified nonce back to queue.
+func (pool *TxPool) demoteFrom(address types.Address, nonce uint64) {
+	pending := pool.pending.TxGroup(address)
+	if pending == nil {
+		return
+	}
+	for elem := pending.Front(); elem != nil; {
+		nextElem := elem.Next()
+		tx := elem.Value.Tx
+		if tx.Data.AccountNonce >= nonce {
+			pool.pending.RemoveTx(tx.Hash.Load().(types.Hash))
+			if err := pool.makeAccountRoom(tx); err != nil {
+				log.Debug("failed to demote tx %x, as: %v", tx.Hash.Load(), err)
+				pool.discard(TxsEvicted, TxStatusEvicted, tx)
+			} else if err := pool.queue.AddTimedTx(elem.Value); err != nil {
+				log.Debug("failed to demote tx %x, as: %v", tx.Hash.Load(), err)
+				pool.discard(TxsEvicted, TxStatusEvicted, tx)
+			} else {
+				pool.emit(TxsQueued, tx)
+			}
+		}
+		elem = nextElem
+	}
+}
+
+// makeAccountRoom makes room in queue for tx if the account's queue is full, by discarding the account's tx with
+// the highest nonce. ErrAccountLimitExceeded will be returned if tx has the highest nonce itself.
+func (pool *TxPool) makeAccountRoom(tx *types.Transaction) error {
+	from := *tx.Data.From
+	if uint64(pool.queue.AccountLen(from)) < pool.config.AccountQueue ||
+		pool.queue.GetTxByNonce(from, tx.Data.AccountNonce) != nil {
+		return nil
+	}
+	tail := pool.queue.TxGroup(from).Back().Value.Tx
+	if tx.Data.AccountNonce > tail.Data.AccountNonce {
+		return ErrAccountLimitExceeded
+	}
+	log.Debug("The queue of account %x is full, discard tx %x.", from, tail.Hash.Load())
+	monitor.JTMetrics.TxpoolDiscardedTx.Add(float64(1))
+	pool.queue.RemoveTx(tail.Hash.Load().(types.Hash))
+	pool.discard(TxsEvicted, TxStatusEvicted, tail)
+	return nil
+}
+
+// evict makes room in the full buffer for tx, and returns the buffer tx should be added to. tx is rejected if it's
+// not pricier than the cheapest remote tx of pending and queue, unless tx is local. The non-executable txs are
+// discarded first, so an executable tx waits in queue for room in pending if the cheapest tx is a queued one. The
+// subsequent pending txs of the discarded one's account are moved to queue as they are no longer executable. Local tx
+// is allowed to exceed the buffer limit if there is no remote tx to discard. Expired txs are left to removeExpired.
+func (pool *TxPool) evict(buffer *tools.ListBuffer, tx *types.Transaction, local bool) (*tools.ListBuffer, error) {
+	cheapestQueued, cheapestPending := pool.queue.Cheapest(), pool.pending.Cheapest()
+	cheapest := cheapestQueued
+	if cheapest == nil || cheapestPending != nil && cheaper(cheapestPending, cheapest) {
+		cheapest = cheapestPending
+	}
+	if !local && cheapest != nil && !cheaper(cheapest, tx) {
+		return nil, ErrUnderpriced
+	}
+	if buffer == pool.pending && cheapest != nil && cheapest == cheapestQueued {
+		if buffer = pool.queue; !buffer.IsFull() {
+			return buffer, nil
+		}
+	}
+
+	victim := cheapestPending
+	if buffer == pool.queue {
+		victim = cheapestQueued
+	}
+	if victim == nil {
+		if local {
+			return buffer, nil
+		}
+		// the buffer is full of local txs
+		return nil, ErrPoolFull
+	}
+	if !local && !cheaper(victim, tx) {
+		return nil, ErrUnderpriced
+	}
+	pool.evictTx(buffer, victim)
+	return buffer, nil
+}
+
+// cheaper checks whether the price of tx is lower than the other one.
+func cheaper(tx, other *types.Transaction) bool {
+	return common.TxPrice(tx).Cmp(common.TxPrice(other)) < 0
+}
+
+// truncate discards the cheapest remote txs of buffer until it doesn't exceed the limit, which may be exceeded by
+// the txs added in parallel. Local txs are kept even if the limit is exceeded.
+func (pool *TxPool) truncate(buffer *tools.ListBuffer) {
+	for buffer.IsOverflowed() {
+		cheapest := buffer.Cheapest()
+		if cheapest == nil {
+			return
+		}
+		pool.evictTx(buffer, cheapest)
+	}
+}
+
+// evictTx discards tx from the full buffer, the subsequent pending txs of its account are moved to queue as they
+// are no longer executable.
+func (pool *TxPool) evictTx(buffer *tools.ListBuffer, tx *types.Transaction) {
+	log.Debug("Tx pool is full, discard cheapest tx %x.", tx.Hash.Load())
+	monitor.JTMetrics.TxpoolDiscardedTx.Add(float64(1))
+	buffer.RemoveTx(tx.Hash.Load().(types.Hash))
+	pool.discard(TxsEvicted, TxStatusEvicted, tx)
+	if buffer == pool.pending {
+		pool.demoteFrom(*tx.Data.From, tx.Data.AccountNonce+1)
+	}
+}
+
+// reset removes the transactions already executed by chain or no longer affordable by the sender, and moves the
+// left ones between pending and queue according to the latest chain nonce.
+func (pool *TxPool) reset() {
+	for _, address := range pool.pending.Accounts() {
+		chainNonce := pool.getChainNonce(address)
+		pool.pruneStale(pool.pending, address, chainNonce)
+		pool.demote(address, chainNonce)
+		pool.pruneUnaffordable(address)
+	}
+	for _, address := range pool.queue.Accounts() {
+		chainNonce := pool.getChainNonce(address)
+		pool.pruneStale(pool.queue, address, chainNonce)
+		pool.pruneUnaffordable(address)
+		pool.promote(address, pool.pendingNonce(address, chainNonce))
+	}
+}
+
+// pruneStale removes account's txs in buffer whose nonce is lower than chain nonce, as they are already executed by
+// chain. Stale txs are only pruned here on block commit within the write lock, reading txpool never modifies it.
+func (pool *TxPool) pruneStale(buffer *tools.ListBuffer, address types.Address, chainNonce uint64) {
+	if chainNonce > 0 {
+		pool.discard(TxsDropped, TxStatusIncluded, buffer.RemoveOlderTx(address, chainNonce-1)...)
+	}
+}
+
+// affordable checks whether the sender can afford the cumulative cost of tx and its pending txs with lower nonces,
+// the same as pruneUnaffordable does on block commit. The pending txs already executed by chain are not counted.
+func (pool *TxPool) affordable(tx *types.Transaction, chainNonce uint64) bool {
+	cost := common.TxCost(tx)
+	if pending := pool.pending.TxGroup(*tx.Data.From); pending != nil {
+		for elem := pending.Front(); elem != nil; elem = elem.Next() {
+			nonce := elem.Value.Tx.Data.AccountNonce
+			if nonce >= tx.Data.AccountNonce {
+				break
+			}
+			if nonce >= chainNonce {
+				cost.Add(cost, common.TxCost(elem.Value.Tx))
+			}
+		}
+	}
+	return pool.chain.GetBalance(*tx.Data.From).Cmp(cost) >= 0
+}
+
+// pruneUnaffordable drops the first pending tx whose cumulative cost exceeds the account balance, and moves the
+// subsequent ones to queue. Queued txs whose own cost exceeds the balance are dropped.
+func (pool *TxPool) pruneUnaffordable(address types.Address) {
+	balance := pool.chain.GetBalance(address)
+	if pending := pool.pending.TxGroup(address); pending != nil {
+		cost := new(big.Int)
+		for elem := pending.Front(); elem != nil; elem = elem.Next() {
+			tx := elem.Value.Tx
+			if cost.Add(cost, common.TxCost(tx)).Cmp(balance) > 0 {
+				log.Debug("Account %x can't afford pending tx %x, discard it.", address, tx.Hash.Load())
+				pool.pending.RemoveTx(tx.Hash.Load().(types.Hash))
+				pool.discard(TxsDropped, TxStatusDropped, tx)
+				pool.demoteFrom(address, tx.Data.AccountNonce+1)
+				break
+			}
+		}
+	}
+	if queued := pool.queue.TxGroup(address); queued != nil {
+		for elem := queued.Front(); elem != nil; {
+			nextElem := elem.Next()
+			tx := elem.Value.Tx
+			if common.TxCost(tx).Cmp(balance) > 0 {
+				log.Debug("Account %x can't afford queued tx %x, discard it.", address, tx.Hash.Load())
+				pool.queue.RemoveTx(tx.Hash.Load().(types.Hash))
+				pool.discard(TxsDropped, TxStatusDropped, tx)
+			}
+			elem = nextElem
+		}
+	}
+}
+
+// removeExpired removes the remote transactions staying in pool longer than the max cache time, the subsequent
+// pending txs of the removed ones are moved to queue as they are no longer executable.
+func (pool *TxPool) removeExpired() {
+	expired := append(pool.pending.ExpiredTxs(), pool.queue.ExpiredTxs()...)
+	for _, tx := range expired {
+		hash := tx.Hash.Load().(types.Hash)
+		if pool.pending.GetTx(hash) != nil {
+			pool.pending.RemoveTx(hash)
+			pool.demoteFrom(*tx.Data.From, tx.Data.AccountNonce+1)
+		} else {
+			pool.queue.RemoveTx(hash)
+		}
+	}
+	pool.discard(TxsExpired, TxStatusExpired, expired...)
+	if len(expired) > 0 {
+		log.Debug("Removed %d expired txs from tx pool.", len(expired))
+		monitor.JTMetrics.TxpoolDiscardedTx.Add(float64(len(expired)))
+	}
+}
+
+// SubscribeNewTxs subscribes ch to the lifecycle events of txs in txpool.
+func (pool *TxPool) SubscribeNewTxs(ch chan<- NewTxsEvent) Subscription {
+	return pool.feed.subscribe(ch)
+}
+
+// emit records the lifecycle event of txs, the events are delivered to subscribers after the lock is released.
+func (pool *TxPool) emit(kind TxsEventKind, txs ...*types.Transaction) {
+	if len(txs) == 0 {
+		return
+	}
+	pool.eventsMu.Lock()
+	defer pool.eventsMu.Unlock()
+	if n := len(pool.events); n > 0 && pool.events[n-1].Kind == kind {
+		pool.events[n-1].Txs = append(pool.events[n-1].Txs, txs...)
+		return
+	}
+	pool.events = append(pool.events, NewTxsEvent{Kind: kind, Txs: append([]*types.Transaction(nil), txs...)})
+}
+
+// discard emits the lifecycle event of the removed txs, and records their status.
+func (pool *TxPool) discard(kind TxsEventKind, status TxStatusKind, txs ...*types.Transaction) {
+	pool.emit(kind, txs...)
+	now := time.Now()
+	for _, tx := range txs {
+		pool.history.Add(common.TxHash(tx), TxStatus{Kind: status, Time: now})
+	}
+}
+
+// flushEvents delivers the recorded events to subscribers. The events are taken out and sent within eventsMu, so
+// they are delivered in the order of being recorded even if several goroutines flush them concurrently.
+func (pool *TxPool) flushEvents() {
+	pool.eventsMu.Lock()
+	defer pool.eventsMu.Unlock()
+	pool.feed.send(pool.events)
+	pool.events = nil
+}
+
+func minUint64(a, b uint64) uint64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// journalTx adds tx to the journal.
+func (pool *TxPool) journalTx(tx *types.Transaction) {
+	if pool.journal == nil {
+		return
+	}
+	if err := pool.journal.insert(tx); err != nil {
+		log.Warn("Failed to journal transaction %x, as: %v", tx.Hash.Load(), err)
+	}
+}
+
+// rotateJournal regenerates the journal with the local transactions currently in pool.
+func (pool *TxPool) rotateJournal() {
+	if err := pool.journal.rotate(pool.localTxs()); err != nil {
+		log.Warn("Failed to rotate transaction journal, as: %v", err)
+	}
+}
+
+// localTxs returns the transactions of local accounts in pending and queue.
+func (pool *TxPool) localTxs() []*types.Transaction {
+	txs := make([]*types.Transaction, 0)
+	for _, buffer := range []*tools.ListBuffer{pool.pending, pool.queue} {
+		for _, addr := range buffer.Accounts() {
+			if pool.locals.Contains(addr) {
+				txs = append(txs, accountTxs(buffer, addr)...)
+			}
+		}
+	}
+	return txs
+}
+
+// groupedTxs returns the txs in buffer grouped by account and sorted by nonce.
+func groupedTxs(buffer *tools.ListBuffer) map[types.Address][]*types.Transaction {
+	txs := make(map[types.Address][]*types.Transaction)
+	for _, address := range buffer.Accounts() {
+		txs[address] = accountTxs(buffer, address)
+	}
+	return txs
+}
+
+// accountTxs returns the txs of account in buffer sorted by nonce.
+func accountTxs(buffer *tools.ListBuffer, address types.Address) []*types.Transaction {
+	l := buffer.TxGroup(address)
+	if l == nil {
+		return nil
+	}
+	txs := make([]*types.Transaction, 0, l.Len())
+	for elem := l.Front(); elem != nil; elem = elem.Next() {
+		txs = append(txs, elem.Value.Tx)
+	}
+	return txs
+}
+
+// accountLock returns the lock of the shard which the account is distributed to.
+func (pool *TxPool) accountLock(address types.Address) *sync.Mutex {
+	return &pool.shards[tools.ShardOf(address, accountShards)]
+}
+
+// lockShards locks all the account shards in order, to read a consistent snapshot of all accounts.
+func (pool *TxPool) lockShards() {
+	for i := range pool.shards {
+		pool.shards[i].Lock()
+	}
+}
+
+// unlockShards unlocks all the account shards.
+func (pool *TxPool) unlockShards() {
+	for i := len(pool.shards) - 1; i >= 0; i-- {
+		pool.shards[i].Unlock()
+	}
+}
+
+// len returns the number of transactions in pending and queue.
+func (pool *TxPool) len() int {
+	return pool.pending.Len() + pool.queue.Len()
 }
 
 // get account's nonce from chain
@@ -182,17 +1377,103 @@ func (pool *TxPool) getChainNonce(address types.Address) uint64 {
 	return pool.chain.GetNonce(address)
 }
 
-// update chain instance after committing block
+// Reset moves txpool to newHead, the txs in the blocks between oldHead and the common ancestor, which are not
+// included in the blocks between newHead and the common ancestor, are reinjected to txpool through validation.
+// Without both heads, txpool is only reset to the latest chain.
+func (pool *TxPool) Reset(oldHead, newHead *types.Block) {
+	var reinject []*types.Transaction
+	if hasHeader(oldHead) && hasHeader(newHead) && oldHead.HeaderHash != newHead.HeaderHash &&
+		oldHead.HeaderHash != newHead.Header.PrevBlockHash {
+		reinject = pool.reorgTxs(oldHead, newHead)
+	}
+	// the reinjected txs are validated against the new head, so wait until txpool is reset
+	if done := pool.scheduleReorg(true); done != nil {
+		<-done
+	} else {
+		pool.runReorg(nil, true)
+	}
+	if len(reinject) == 0 {
+		return
+	}
+	log.Info("Reinject %d txs of reorg to tx pool.", len(reinject))
+	added, _ := pool.addTxs(reinject, false)
+	pool.notifyBatch(added)
+}
+
+// hasHeader checks whether block and its header are both present.
+func hasHeader(block *types.Block) bool {
+	return block != nil && block.Header != nil
+}
+
+// reorgTxs returns the txs in the blocks of old chain but not in new chain. nil will be returned if the common
+// ancestor can't be found within maxReorgDepth.
+func (pool *TxPool) reorgTxs(oldHead, newHead *types.Block) []*types.Transaction {
+	chain, _ := pool.validationContext()
+	parent := func(block *types.Block) *types.Block {
+		if block.Header.Height == 0 {
+			return nil
+		}
+		parent, err := chain.GetBlockByHash(block.Header.PrevBlockHash)
+		if err != nil {
+			log.Warn("Failed to get block %x, as: %v", block.Header.PrevBlockHash, err)
+			return nil
+		}
+		if !hasHeader(parent) {
+			log.Warn("Block %x has no header.", block.Header.PrevBlockHash)
+			return nil
+		}
+		return parent
+	}
+
+	var discarded []*types.Block
+	var included []*types.Transaction
+	rem, add := oldHead, newHead
+	for depth := 0; rem.HeaderHash != add.HeaderHash; depth++ {
+		if depth >= maxReorgDepth {
+			log.Warn("Skip reinjecting txs of reorg, as the depth exceeds %d.", maxReorgDepth)
+			return nil
+		}
+		if rem.Header.Height >= add.Header.Height {
+			discarded = append(discarded, rem)
+			if rem = parent(rem); rem == nil {
+				return nil
+			}
+		}
+		if add.Header.Height >= rem.Header.Height && add.HeaderHash != rem.HeaderHash {
+			included = append(included, add.Transactions...)
+			if add = parent(add); add == nil {
+				return nil
+			}
+		}
+	}
+
+	inNewChain := make(map[types.Hash]struct{}, len(included))
+	for _, tx := range included {
+		inNewChain[common.TxHash(tx)] = struct{}{}
+	}
+	reinject := make([]*types.Transaction, 0)
+	for i := len(discarded) - 1; i >= 0; i-- {
+		for _, tx := range discarded[i].Transactions {
+			if _, ok := inNewChain[common.TxHash(tx)]; !ok {
+				reinject = append(reinject, tx)
+			}
+		}
+	}
+	return reinject
+}
+
+// update chain instance after committing block, txpool is reset by the reorg loop if it's running.
 func (pool *TxPool) updateChainInstance(event interface{}) {
-	pool.mu.Lock()
-	defer pool.mu.Unlock()
-	pool.updateChainInstanceWithoutLock()
+	if pool.scheduleReorg(true) == nil {
+		pool.runReorg(nil, true)
+	}
 }
 
 // update chain instance after committing block
 func (pool *TxPool) updateChainInstanceWithoutLock() {
 	if latestRepo, err := repository.NewLatestStateRepository(); err == nil {
 		pool.chain = latestRepo
+		pool.head++
 	} else {
 		log.Error("failed to get latest blockchain, as: %v. We will panic tx pool, as error is not recoverable", err)
 		panic(fmt.Sprintf("failed to get latest blockchain, as: %v", err))
@@ -1,14 +1,18 @@
 package txpool
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/DSiSc/craft/log"
 	"github.com/DSiSc/craft/types"
+	"github.com/DSiSc/crypto-suite/crypto"
 	"github.com/DSiSc/monkey"
 	"github.com/DSiSc/repository"
 	"github.com/DSiSc/txpool/common"
+	"github.com/DSiSc/txpool/tools"
 	"github.com/stretchr/testify/assert"
+	"math"
 	"math/big"
 	"reflect"
 	"sync"
@@ -170,6 +174,124 @@ func mock_samefrom_transactions(num int) []*types.Transaction {
 	return txList
 }
 
+// mock transactions of several accounts, the transactions of each account are sorted by nonce
+func mock_accounts_transactions(accounts, num int) [][]*types.Transaction {
+	txs := make([][]*types.Transaction, accounts)
+	for a := 0; a < accounts; a++ {
+		from := common.HexToAddress(fmt.Sprintf("0x%d", 100+a))
+		for i := 0; i < num; i++ {
+			tx := common.NewTransaction(uint64(i), from, new(big.Int), 0, big.NewInt(int64(a+1)), nil, from)
+			common.TxHash(tx)
+			txs[a] = append(txs[a], tx)
+		}
+	}
+	return txs
+}
+
+// mockChain is a chain whose account nonces are advanced by the committed blocks, all the accounts have the same
+// balance.
+type mockChain struct {
+	mu      sync.RWMutex
+	repo    *repository.Repository
+	base    uint64                   // nonce of the accounts without committed txs
+	nonces  map[types.Address]uint64 // nonces advanced by the committed txs
+	balance *big.Int
+	queries int // number of nonce queries
+}
+
+// newMockChain creates a chain whose accounts start from nonce 0 with unlimited balance, and patches the repository
+// to read it.
+func newMockChain() *mockChain {
+	chain := &mockChain{
+		repo:    &repository.Repository{},
+		nonces:  make(map[types.Address]uint64),
+		balance: big.NewInt(math.MaxInt64),
+	}
+	chain.patch()
+	return chain
+}
+
+// commit advances the nonces of the accounts by the txs of block.
+func (chain *mockChain) commit(block []*types.Transaction) {
+	chain.mu.Lock()
+	defer chain.mu.Unlock()
+	for _, tx := range block {
+		if tx.Data.AccountNonce >= chain.nonceOf(*tx.Data.From) {
+			chain.nonces[*tx.Data.From] = tx.Data.AccountNonce + 1
+		}
+	}
+}
+
+// nonce returns the nonce of account.
+func (chain *mockChain) nonce(address types.Address) uint64 {
+	chain.mu.Lock()
+	defer chain.mu.Unlock()
+	chain.queries++
+	return chain.nonceOf(address)
+}
+
+// nonceOf returns the nonce of account without lock.
+func (chain *mockChain) nonceOf(address types.Address) uint64 {
+	if nonce, ok := chain.nonces[address]; ok {
+		return nonce
+	}
+	return chain.base
+}
+
+// setNonce sets the nonce of all the accounts.
+func (chain *mockChain) setNonce(nonce uint64) {
+	chain.mu.Lock()
+	defer chain.mu.Unlock()
+	chain.base = nonce
+	chain.nonces = make(map[types.Address]uint64)
+}
+
+// setBalance sets the balance of all the accounts.
+func (chain *mockChain) setBalance(balance int64) {
+	chain.mu.Lock()
+	defer chain.mu.Unlock()
+	chain.balance = big.NewInt(balance)
+}
+
+// nonceQueries returns the number of nonce queries.
+func (chain *mockChain) nonceQueries() int {
+	chain.mu.RLock()
+	defer chain.mu.RUnlock()
+	return chain.queries
+}
+
+// patch patches the repository to read the nonces and balances of mock chain.
+func (chain *mockChain) patch() {
+	monkey.Patch(repository.NewLatestStateRepository, func() (*repository.Repository, error) {
+		return chain.repo, nil
+	})
+	getNonce := func(_ *repository.Repository, address types.Address) uint64 {
+		return chain.nonce(address)
+	}
+	getBalance := func(*repository.Repository, types.Address) *big.Int {
+		chain.mu.RLock()
+		defer chain.mu.RUnlock()
+		return new(big.Int).Set(chain.balance)
+	}
+	monkey.PatchInstanceMethod(reflect.TypeOf(chain.repo), "GetNonce", getNonce)
+	monkey.PatchInstanceMethod(reflect.TypeOf(chain.repo), "GetBalance", getBalance)
+}
+
+// age makes the txs in txpool look like being added d earlier.
+func age(pool *TxPool, d time.Duration, txs ...*types.Transaction) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	for _, tx := range txs {
+		for _, buffer := range []*tools.ListBuffer{pool.pending, pool.queue} {
+			if l := buffer.TxGroup(*tx.Data.From); l != nil {
+				if timedTx := l.Get(tx.Data.AccountNonce); timedTx != nil && timedTx.Tx == tx {
+					timedTx.TimeStamp = timedTx.TimeStamp.Add(-d)
+				}
+			}
+		}
+	}
+}
+
 // Test new a txpool
 func Test_NewTxPool(t *testing.T) {
 	assert := assert.New(t)
@@ -189,13 +311,7 @@ func Test_NewTxPool(t *testing.T) {
 // Test add a tx to txpool
 func Test_AddTx(t *testing.T) {
 	defer monkey.UnpatchAll()
-	chain := &repository.Repository{}
-	monkey.Patch(repository.NewLatestStateRepository, func() (*repository.Repository, error) {
-		return chain, nil
-	})
-	monkey.PatchInstanceMethod(reflect.TypeOf(chain), "GetNonce", func(*repository.Repository, types.Address) uint64 {
-		return 0
-	})
+	newMockChain()
 	assert := assert.New(t)
 
 	txList := mock_transactions(3)
@@ -225,32 +341,29 @@ func Test_AddTx(t *testing.T) {
 	// add duplicate tx to txpool
 	err = txpool.AddTx(txList[0])
 	assert.NotNil(err)
-	assert.Equal(1, instance.txBuffer.Len(), "they should be equal")
+	assert.Equal(1, instance.pending.Len(), "they should be equal")
 
 	err = txpool.AddTx(txList[1])
 	assert.Nil(err)
-	assert.Equal(2, instance.txBuffer.Len(), "they should be equal")
+	assert.Equal(2, instance.pending.Len(), "they should be equal")
 
 	err = txpool.AddTx(txList[2])
 	assert.NotNil(err)
 
-	instance.config.TxMaxCacheTime = 1
-	time.Sleep(2 * time.Second)
+	// the expired tx is removed to make room
+	age(instance, time.Hour, txList[0])
+	instance.mu.Lock()
+	instance.removeExpired()
+	instance.mu.Unlock()
 	err = txpool.AddTx(txList[2])
 	assert.Nil(err)
-	assert.Equal(2, instance.txBuffer.Len())
+	assert.Equal(2, instance.pending.Len())
 }
 
 // Test Get a tx from txpool
 func Test_GetTxs(t *testing.T) {
 	defer monkey.UnpatchAll()
-	chain := &repository.Repository{}
-	monkey.Patch(repository.NewLatestStateRepository, func() (*repository.Repository, error) {
-		return chain, nil
-	})
-	monkey.PatchInstanceMethod(reflect.TypeOf(chain), "GetNonce", func(*repository.Repository, types.Address) uint64 {
-		return 0
-	})
+	chain := newMockChain()
 	assert := assert.New(t)
 	tx := mock_transactions(1)[0]
 	assert.NotNil(tx)
@@ -263,23 +376,22 @@ func Test_GetTxs(t *testing.T) {
 	assert.NotNil(returnedTx)
 	assert.Equal(1, len(returnedTx))
 
-	monkey.PatchInstanceMethod(reflect.TypeOf(chain), "GetNonce", func(*repository.Repository, types.Address) uint64 {
-		return 1
-	})
+	chain.setNonce(1)
 	returnedTx = txpool.GetTxs()
 	assert.Equal(0, len(returnedTx))
+
+	// stale tx is skipped but kept by GetTxs, and pruned on block commit
+	hash := common.TxHash(tx)
+	assert.NotNil(txpool.Get(hash))
+	txpool.(*TxPool).updateChainInstance(nil)
+	assert.Nil(txpool.Get(hash))
+	assert.Equal(TxStatusIncluded, txpool.Status(hash).Kind)
 }
 
 // Test DelTxs txs from txpool
 func Test_DelTxs(t *testing.T) {
 	defer monkey.UnpatchAll()
-	chain := &repository.Repository{}
-	monkey.Patch(repository.NewLatestStateRepository, func() (*repository.Repository, error) {
-		return chain, nil
-	})
-	monkey.PatchInstanceMethod(reflect.TypeOf(chain), "GetNonce", func(*repository.Repository, types.Address) uint64 {
-		return 0
-	})
+	newMockChain()
 	assert := assert.New(t)
 	txs := mock_transactions(3)
 
@@ -287,33 +399,42 @@ func Test_DelTxs(t *testing.T) {
 	assert.NotNil(txpool)
 	pool := txpool.(*TxPool)
 	pool.AddTx(txs[0])
-	assert.Equal(1, pool.txBuffer.Len())
+	assert.Equal(1, pool.pending.Len())
 
 	pool.DelTxs([]*types.Transaction{txs[1]})
-	assert.Equal(1, pool.txBuffer.Len())
+	assert.Equal(1, pool.pending.Len())
 
 	pool.DelTxs([]*types.Transaction{txs[0]})
-	assert.Equal(0, pool.txBuffer.Len())
+	assert.Equal(0, pool.pending.Len())
 
 	pool.AddTx(txs[0])
 	pool.AddTx(txs[1])
 	pool.AddTx(txs[2])
-	assert.Equal(3, pool.txBuffer.Len())
+	assert.Equal(3, pool.pending.Len())
 	pool.DelTxs([]*types.Transaction{txs[1], txs[2]})
-	assert.Equal(1, pool.txBuffer.Len())
+	assert.Equal(1, pool.pending.Len())
 	pool.DelTxs([]*types.Transaction{txs[0]})
-	assert.Equal(0, pool.txBuffer.Len())
+	assert.Equal(0, pool.pending.Len())
+
+	// the txs promoted by DelTxs never exceed the limit of pending
+	config := DefaultTxPoolConfig
+	config.GlobalSlots = 2
+	txpool = NewTxPool(config, NewMockEvent())
+	accountsTxs := mock_accounts_transactions(2, 7)
+	cheap, pricy := accountsTxs[0], accountsTxs[1]
+	for _, tx := range []*types.Transaction{cheap[5], cheap[6], pricy[0], pricy[1]} {
+		assert.Nil(txpool.AddTx(tx))
+	}
+	txpool.DelTxs([]*types.Transaction{cheap[4]})
+	pending, queued := txpool.Stats()
+	assert.Equal(2, pending)
+	assert.True(queued <= 1)
+	assert.Equal(TxStatusEvicted, txpool.Status(common.TxHash(cheap[5])).Kind)
 }
 
 func TestGetTxByHash(t *testing.T) {
 	defer monkey.UnpatchAll()
-	chain := &repository.Repository{}
-	monkey.Patch(repository.NewLatestStateRepository, func() (*repository.Repository, error) {
-		return chain, nil
-	})
-	monkey.PatchInstanceMethod(reflect.TypeOf(chain), "GetNonce", func(*repository.Repository, types.Address) uint64 {
-		return 0
-	})
+	newMockChain()
 	assert := assert.New(t)
 	tx := mock_transactions(10)[9]
 	assert.NotNil(tx)
@@ -321,12 +442,21 @@ func TestGetTxByHash(t *testing.T) {
 	assert.NotNil(txpool)
 	pool := txpool.(*TxPool)
 
-	exceptTx := GetTxByHash(common.TxHash(tx))
+	exceptTx := pool.GetTxByHash(common.TxHash(tx))
 	assert.Nil(exceptTx)
 
 	// try to get exist tx
 	err := pool.AddTx(tx)
 	assert.Nil(err)
+	exceptTx = pool.GetTxByHash(common.TxHash(tx))
+	assert.Equal(common.TxHash(tx), common.TxHash(exceptTx))
+
+	// try to get tx from default txpool
+	RegisterDefaultTxPool(nil)
+	assert.Nil(GetTxByHash(common.TxHash(tx)))
+	RegisterDefaultTxPool(txpool)
+	defer RegisterDefaultTxPool(nil)
+	assert.Equal(txpool, DefaultTxPool())
 	exceptTx = GetTxByHash(common.TxHash(tx))
 	assert.Equal(common.TxHash(tx), common.TxHash(exceptTx))
 }
@@ -334,13 +464,7 @@ func TestGetTxByHash(t *testing.T) {
 func TestGetPoolNonce(t *testing.T) {
 	defer monkey.UnpatchAll()
 	assert := assert.New(t)
-	chain := &repository.Repository{}
-	monkey.Patch(repository.NewLatestStateRepository, func() (*repository.Repository, error) {
-		return chain, nil
-	})
-	monkey.PatchInstanceMethod(reflect.TypeOf(chain), "GetNonce", func(*repository.Repository, types.Address) uint64 {
-		return 0
-	})
+	newMockChain()
 	var txs []*types.Transaction
 	txpool := NewTxPool(DefaultTxPoolConfig, NewMockEvent())
 
@@ -355,23 +479,23 @@ func TestGetPoolNonce(t *testing.T) {
 
 	txpool.AddTx(txs[0])
 	txpool.AddTx(txs[1])
-	exceptNonce := GetPoolNonce(mockFromAddress)
+	exceptNonce := txpool.GetPoolNonce(mockFromAddress)
 	assert.Equal(uint64(1), exceptNonce)
 
 	txpool.AddTx(txs[2])
-	exceptNonce = GetPoolNonce(mockFromAddress)
+	exceptNonce = txpool.GetPoolNonce(mockFromAddress)
 	assert.Equal(uint64(2), exceptNonce)
+
+	// try to get nonce from default txpool
+	assert.Equal(uint64(0), GetPoolNonce(mockFromAddress))
+	RegisterDefaultTxPool(txpool)
+	defer RegisterDefaultTxPool(nil)
+	assert.Equal(uint64(2), GetPoolNonce(mockFromAddress))
 }
 
 func TestNewTxPool(t *testing.T) {
 	defer monkey.UnpatchAll()
-	chain := &repository.Repository{}
-	monkey.Patch(repository.NewLatestStateRepository, func() (*repository.Repository, error) {
-		return chain, nil
-	})
-	monkey.PatchInstanceMethod(reflect.TypeOf(chain), "GetNonce", func(*repository.Repository, types.Address) uint64 {
-		return 0
-	})
+	newMockChain()
 	var mockTxPoolConfig = TxPoolConfig{
 		GlobalSlots:    4096,
 		MaxTrsPerBlock: 512,
@@ -383,7 +507,7 @@ func TestNewTxPool(t *testing.T) {
 		txpool.AddTx(transactions[index])
 	}
 	txs := txpool.(*TxPool)
-	lens := txs.txBuffer.Len()
+	lens := txs.pending.Len()
 	assert.Equal(t, 4096, lens)
 
 	mm := txpool.GetTxs()
@@ -392,13 +516,7 @@ func TestNewTxPool(t *testing.T) {
 
 func TestNewTxPool1(t *testing.T) {
 	defer monkey.UnpatchAll()
-	chain := &repository.Repository{}
-	monkey.Patch(repository.NewLatestStateRepository, func() (*repository.Repository, error) {
-		return chain, nil
-	})
-	monkey.PatchInstanceMethod(reflect.TypeOf(chain), "GetNonce", func(*repository.Repository, types.Address) uint64 {
-		return 0
-	})
+	newMockChain()
 	var mockTxPoolConfig = TxPoolConfig{
 		GlobalSlots:    4096,
 		MaxTrsPerBlock: 512,
@@ -410,7 +528,7 @@ func TestNewTxPool1(t *testing.T) {
 		txpool.AddTx(transactions[index])
 	}
 	txs := txpool.(*TxPool)
-	lens := txs.txBuffer.Len()
+	lens := txs.pending.Len()
 	assert.Equal(t, 4096, lens)
 
 	readyTxs := txpool.GetTxs()
@@ -427,3 +545,796 @@ func TestNewTxPool1(t *testing.T) {
 	readyTxs = txpool.GetTxs()
 	assert.Equal(t, 512, len(readyTxs))
 }
+
+func TestTxPool_PendingAndQueue(t *testing.T) {
+	defer monkey.UnpatchAll()
+	newMockChain()
+	assert := assert.New(t)
+	txs := mock_samefrom_transactions(4)
+	txpool := NewTxPool(DefaultTxPoolConfig, NewMockEvent())
+	pool := txpool.(*TxPool)
+
+	// tx with nonce gap goes to queue
+	assert.Nil(pool.AddTx(txs[0]))
+	assert.Nil(pool.AddTx(txs[2]))
+	assert.Nil(pool.AddTx(txs[3]))
+	assert.Equal(1, pool.pending.Len())
+	assert.Equal(2, pool.queue.Len())
+	assert.Equal(1, len(pool.GetTxs()))
+
+	// filling the gap promotes queued txs
+	assert.Nil(pool.AddTx(txs[1]))
+	assert.Equal(4, pool.pending.Len())
+	assert.Equal(0, pool.queue.Len())
+	assert.Equal(4, len(pool.GetTxs()))
+}
+
+func TestTxPool_Reset(t *testing.T) {
+	defer monkey.UnpatchAll()
+	chain := newMockChain()
+	assert := assert.New(t)
+	txs := mock_samefrom_transactions(4)
+	txpool := NewTxPool(DefaultTxPoolConfig, NewMockEvent())
+	pool := txpool.(*TxPool)
+	assert.Nil(pool.AddTx(txs[0]))
+	assert.Nil(pool.AddTx(txs[1]))
+	assert.Nil(pool.AddTx(txs[3]))
+	assert.Equal(2, pool.pending.Len())
+	assert.Equal(1, pool.queue.Len())
+
+	// chain nonce moves beyond pending txs, queued tx becomes executable once tx 2 was committed
+	chain.setNonce(3)
+	pool.updateChainInstance(nil)
+	assert.Equal(1, pool.pending.Len())
+	assert.Equal(0, pool.queue.Len())
+	assert.NotNil(pool.pending.GetTx(common.TxHash(txs[3])))
+
+	// pending txs are demoted if chain nonce falls behind them
+	pool.DelTxs([]*types.Transaction{txs[3]})
+	assert.Nil(pool.AddTx(txs[3]))
+	chain.setNonce(2)
+	pool.updateChainInstance(nil)
+	assert.Equal(0, pool.pending.Len())
+	assert.Equal(1, pool.queue.Len())
+}
+
+func TestTxPool_GetTxsByPrice(t *testing.T) {
+	defer monkey.UnpatchAll()
+	newMockChain()
+	assert := assert.New(t)
+	var mockTxPoolConfig = TxPoolConfig{
+		GlobalSlots:    4096,
+		MaxTrsPerBlock: 3,
+	}
+	txpool := NewTxPool(mockTxPoolConfig, NewMockEvent())
+	txs := mock_transactions(4)
+	for i, tx := range txs {
+		tx.Data.Price = big.NewInt(int64(i))
+		assert.Nil(txpool.AddTx(tx))
+	}
+	sameFromTxs := mock_samefrom_transactions(5)
+	sameFromTxs[0].Data.Price = big.NewInt(10)
+	sameFromTxs[1].Data.Price = big.NewInt(100)
+	assert.Nil(txpool.AddTx(sameFromTxs[1]))
+	assert.Nil(txpool.AddTx(sameFromTxs[0]))
+
+	// higher price first, but nonce order of same account is kept
+	readyTxs := txpool.GetTxs()
+	assert.Equal(3, len(readyTxs))
+	assert.Equal(common.TxHash(sameFromTxs[0]), common.TxHash(readyTxs[0]))
+	assert.Equal(common.TxHash(sameFromTxs[1]), common.TxHash(readyTxs[1]))
+	assert.Equal(common.TxHash(txs[3]), common.TxHash(readyTxs[2]))
+}
+
+func TestTxPool_GetTxsWithLimits(t *testing.T) {
+	defer monkey.UnpatchAll()
+	newMockChain()
+	assert := assert.New(t)
+	txpool := NewTxPool(DefaultTxPoolConfig, NewMockEvent())
+	txs := mock_transactions(3)
+	for i, tx := range txs {
+		tx.Data.Price = big.NewInt(int64(10 - i))
+		tx.Data.GasLimit = 100
+		assert.Nil(txpool.AddTx(tx))
+	}
+	sameFromTxs := mock_samefrom_transactions(5)
+	for i := 0; i < 2; i++ {
+		sameFromTxs[i].Data.Price = big.NewInt(100)
+		sameFromTxs[i].Data.GasLimit = 1000
+		assert.Nil(txpool.AddTx(sameFromTxs[i]))
+	}
+
+	assert.Equal(5, len(txpool.GetTxsWithLimits(0, 0, 0)))
+	assert.Equal(2, len(txpool.GetTxsWithLimits(2, 0, 0)))
+
+	// the expensive account is skipped as a whole once the gas budget can't hold its next tx
+	readyTxs := txpool.GetTxsWithLimits(0, 1200, 0)
+	assert.Equal(3, len(readyTxs))
+	assert.Equal(common.TxHash(sameFromTxs[0]), common.TxHash(readyTxs[0]))
+	assert.Equal(common.TxHash(txs[0]), common.TxHash(readyTxs[1]))
+	assert.Equal(common.TxHash(txs[1]), common.TxHash(readyTxs[2]))
+
+	readyTxs = txpool.GetTxsWithLimits(0, 0, common.TxSize(sameFromTxs[0])+common.TxSize(sameFromTxs[1]))
+	assert.Equal(2, len(readyTxs))
+	assert.Equal(common.TxHash(sameFromTxs[1]), common.TxHash(readyTxs[1]))
+
+	// the sizes are cached in txpool instead of the txs
+	size, ok := txpool.(*TxPool).sizes.Get(common.TxHash(sameFromTxs[0]))
+	assert.True(ok)
+	assert.Equal(common.TxSize(sameFromTxs[0]), size)
+	assert.Nil(sameFromTxs[0].Size.Load())
+}
+
+func TestTxPool_ReplaceTx(t *testing.T) {
+	defer monkey.UnpatchAll()
+	newMockChain()
+	assert := assert.New(t)
+	txpool := NewTxPool(DefaultTxPoolConfig, NewMockEvent())
+	pool := txpool.(*TxPool)
+	tx := mock_transactions(1)[0]
+	tx.Data.Price = big.NewInt(100)
+	assert.Nil(txpool.AddTx(tx))
+
+	replaceTx := common.NewTransaction(0, *tx.Data.Recipient, big.NewInt(1), 0, big.NewInt(105), nil, *tx.Data.From)
+	assert.True(errors.Is(txpool.AddTx(replaceTx), ErrReplaceUnderpriced))
+	assert.NotNil(pool.pending.GetTx(common.TxHash(tx)))
+
+	replaceTx = common.NewTransaction(0, *tx.Data.Recipient, big.NewInt(1), 0, big.NewInt(110), nil, *tx.Data.From)
+	assert.Nil(txpool.AddTx(replaceTx))
+	assert.Nil(pool.pending.GetTx(common.TxHash(tx)))
+	assert.NotNil(pool.pending.GetTx(common.TxHash(replaceTx)))
+	assert.Equal(1, pool.pending.Len())
+}
+
+func TestTxPool_EvictCheapest(t *testing.T) {
+	defer monkey.UnpatchAll()
+	newMockChain()
+	assert := assert.New(t)
+	var mockTxPoolConfig = TxPoolConfig{
+		GlobalSlots:    3,
+		MaxTrsPerBlock: 10,
+	}
+	txpool := NewTxPool(mockTxPoolConfig, NewMockEvent())
+	pool := txpool.(*TxPool)
+	sameFromTxs := mock_samefrom_transactions(5)
+	sameFromTxs[0].Data.Price = big.NewInt(1)
+	sameFromTxs[1].Data.Price = big.NewInt(10)
+	assert.Nil(txpool.AddTx(sameFromTxs[0]))
+	assert.Nil(txpool.AddTx(sameFromTxs[1]))
+	txs := mock_transactions(3)
+	txs[0].Data.Price = big.NewInt(5)
+	assert.Nil(txpool.AddTx(txs[0]))
+
+	// cheaper than the cheapest resident tx
+	txs[1].Data.Price = big.NewInt(1)
+	assert.True(errors.Is(txpool.AddTx(txs[1]), ErrUnderpriced))
+
+	// the cheapest tx is evicted, its subsequent tx is moved to queue
+	txs[2].Data.Price = big.NewInt(2)
+	assert.Nil(txpool.AddTx(txs[2]))
+	assert.Nil(pool.pending.GetTx(common.TxHash(sameFromTxs[0])))
+	assert.NotNil(pool.queue.GetTx(common.TxHash(sameFromTxs[1])))
+	assert.Equal(2, pool.pending.Len())
+	assert.Equal(1, pool.queue.Len())
+
+	// the cheapest tx is evicted even if the pool has expired txs, which are left to the janitor
+	mockTxPoolConfig.GlobalSlots = 2
+	mockTxPoolConfig.TxMaxCacheTime = 1
+	txpool = NewTxPool(mockTxPoolConfig, NewMockEvent())
+	pool = txpool.(*TxPool)
+	assert.Nil(txpool.AddTx(sameFromTxs[0]))
+	assert.Nil(txpool.AddTx(sameFromTxs[1]))
+	age(pool, time.Hour, sameFromTxs[0])
+	assert.Nil(txpool.AddTx(txs[0]))
+	assert.Equal(TxStatusEvicted, txpool.Status(common.TxHash(sameFromTxs[0])).Kind)
+	assert.Equal(TxStatusQueued, txpool.Status(common.TxHash(sameFromTxs[1])).Kind)
+	assert.Equal(TxStatusPending, txpool.Status(common.TxHash(txs[0])).Kind)
+
+	// the cheaper non-executable tx is evicted first, the executable tx waits in queue for room in pending
+	mockTxPoolConfig.GlobalQueue = 1
+	txpool = NewTxPool(mockTxPoolConfig, NewMockEvent())
+	txs = mock_transactions(4)
+	for i, price := range []int64{5, 10, 3, 1} {
+		txs[i].Data.Price = big.NewInt(price)
+	}
+	futureTx := mock_samefrom_transactions(5)[1]
+	futureTx.Data.Price = big.NewInt(2)
+	assert.Nil(txpool.AddTx(txs[0]))
+	assert.Nil(txpool.AddTx(txs[1]))
+	assert.Nil(txpool.AddTx(futureTx))
+	assert.Nil(txpool.AddTx(txs[2]))
+	assert.Equal(TxStatusEvicted, txpool.Status(common.TxHash(futureTx)).Kind)
+	assert.Equal(TxStatusQueued, txpool.Status(common.TxHash(txs[2])).Kind)
+	pending, queued := txpool.Stats()
+	assert.Equal(2, pending)
+	assert.Equal(1, queued)
+
+	// cheaper than the cheapest tx across pending and queue
+	assert.True(errors.Is(txpool.AddTx(txs[3]), ErrUnderpriced))
+}
+
+func TestTxPool_AccountLimits(t *testing.T) {
+	defer monkey.UnpatchAll()
+	newMockChain()
+	assert := assert.New(t)
+	var mockTxPoolConfig = TxPoolConfig{
+		GlobalSlots:    100,
+		GlobalQueue:    100,
+		AccountSlots:   2,
+		AccountQueue:   2,
+		MaxTrsPerBlock: 10,
+	}
+	txpool := NewTxPool(mockTxPoolConfig, NewMockEvent())
+	pool := txpool.(*TxPool)
+	txs := mock_samefrom_transactions(6)
+	assert.Nil(txpool.AddTx(txs[0]))
+	assert.Nil(txpool.AddTx(txs[1]))
+	// executable tx exceeding account slots waits in queue
+	assert.Nil(txpool.AddTx(txs[2]))
+	assert.Equal(2, pool.pending.Len())
+	assert.Equal(1, pool.queue.Len())
+
+	// the tx with highest nonce is discarded first
+	assert.Nil(txpool.AddTx(txs[5]))
+	assert.Nil(txpool.AddTx(txs[4]))
+	assert.Nil(pool.queue.GetTx(common.TxHash(txs[5])))
+	assert.True(errors.Is(txpool.AddTx(txs[5]), ErrAccountLimitExceeded))
+	assert.Equal(2, pool.queue.Len())
+
+	// queued txs are promoted once pending slots are released
+	pool.DelTxs([]*types.Transaction{txs[0]})
+	assert.Equal(2, pool.pending.Len())
+	assert.NotNil(pool.pending.GetTx(common.TxHash(txs[2])))
+	assert.Equal(1, pool.queue.Len())
+
+	// account queue is limited even if tx waits in queue for room in the full pending
+	mockTxPoolConfig.GlobalSlots = 2
+	mockTxPoolConfig.AccountQueue = 1
+	txpool = NewTxPool(mockTxPoolConfig, NewMockEvent())
+	pool = txpool.(*TxPool)
+	txs = mock_samefrom_transactions(6)
+	otherTx := mock_transactions(1)[0]
+	for tx, price := range map[*types.Transaction]int64{txs[0]: 5, otherTx: 5, txs[5]: 1, txs[1]: 10} {
+		tx.Data.Price = big.NewInt(price)
+	}
+	for _, tx := range []*types.Transaction{txs[0], otherTx, txs[5], txs[1]} {
+		assert.Nil(txpool.AddTx(tx))
+	}
+	assert.Equal(TxStatusEvicted, txpool.Status(common.TxHash(txs[5])).Kind)
+	assert.Equal(TxStatusQueued, txpool.Status(common.TxHash(txs[1])).Kind)
+	assert.Equal(1, pool.queue.AccountLen(*txs[1].Data.From))
+}
+
+func TestTxPool_VerifySender(t *testing.T) {
+	defer monkey.UnpatchAll()
+	newMockChain()
+	assert := assert.New(t)
+	signer := common.NewEIP155Signer(big.NewInt(1))
+	mockTxPoolConfig := DefaultTxPoolConfig
+	mockTxPoolConfig.Signer = signer
+	txpool := NewTxPool(mockTxPoolConfig, NewMockEvent())
+
+	key, err := crypto.GenerateKey()
+	assert.Nil(err)
+	from := common.BytesToAddress(crypto.Keccak256(crypto.FromECDSAPub(&key.PublicKey)[1:])[12:])
+	tx := common.NewTransaction(0, from, big.NewInt(1), 21000, big.NewInt(1), nil, from)
+	hash := signer.Hash(tx)
+	sig, err := crypto.Sign(hash[:], key)
+	assert.Nil(err)
+	tx.Data.R, tx.Data.S, tx.Data.V, err = signer.SignatureValues(sig)
+	assert.Nil(err)
+	assert.Nil(txpool.AddTx(tx))
+
+	// recovered sender is cached by pool, tx.From shared with other modules is untouched
+	cached, ok := txpool.(*TxPool).senders.Get(common.TxHash(tx))
+	assert.True(ok)
+	assert.Equal(from, cached)
+	assert.Nil(tx.From.Load())
+
+	// unsigned tx
+	assert.True(errors.Is(txpool.AddTx(mock_transactions(1)[0]), common.ErrInvalidSig))
+
+	// signed by others
+	forgedTx := common.NewTransaction(1, from, big.NewInt(1), 21000, big.NewInt(1), nil, common.HexToAddress("0x1"))
+	hash = signer.Hash(forgedTx)
+	sig, err = crypto.Sign(hash[:], key)
+	assert.Nil(err)
+	forgedTx.Data.R, forgedTx.Data.S, forgedTx.Data.V, err = signer.SignatureValues(sig)
+	assert.Nil(err)
+	assert.True(errors.Is(txpool.AddTx(forgedTx), ErrInvalidSender))
+}
+
+func TestTxPool_PruneUnaffordable(t *testing.T) {
+	defer monkey.UnpatchAll()
+	chain := newMockChain()
+	chain.setBalance(100)
+	assert := assert.New(t)
+	txpool := NewTxPool(DefaultTxPoolConfig, NewMockEvent())
+	pool := txpool.(*TxPool)
+	txs := mock_samefrom_transactions(5)
+	for _, tx := range txs {
+		tx.Data.Amount = big.NewInt(40)
+		tx.Data.GasLimit = 0
+	}
+
+	// unaffordable tx is rejected
+	from := *txs[0].Data.From
+	unaffordableTx := common.NewTransaction(0, *txs[0].Data.Recipient, big.NewInt(101), 0, new(big.Int), nil, from)
+	assert.True(errors.Is(txpool.AddTx(unaffordableTx), ErrInsufficientFunds))
+
+	// tx is rejected if the account can't afford it along with the preceding pending txs
+	assert.Nil(txpool.AddTx(txs[0]))
+	assert.Nil(txpool.AddTx(txs[1]))
+	assert.True(errors.Is(txpool.AddTx(txs[2]), ErrInsufficientFunds))
+	assert.True(errors.Is(txpool.AddTx(txs[4]), ErrInsufficientFunds))
+	assert.Equal(2, pool.pending.Len())
+	assert.Equal(0, pool.queue.Len())
+
+	// the pending tx can be replaced by a pricier one within the balance
+	replacement := common.NewTransaction(1, *txs[1].Data.Recipient, big.NewInt(50), 0, big.NewInt(1), nil, from)
+	assert.Nil(txpool.AddTx(replacement))
+
+	// balance drops after block committed
+	chain.setBalance(30)
+	pool.updateChainInstance(nil)
+	assert.Equal(0, pool.pending.Len())
+	assert.Equal(0, pool.queue.Len())
+
+	chain.setBalance(100)
+	for _, tx := range txs[:2] {
+		assert.Nil(txpool.AddTx(tx))
+	}
+	chain.setBalance(70)
+	pool.updateChainInstance(nil)
+	assert.Equal(1, pool.pending.Len())
+	assert.Nil(pool.pending.GetTx(common.TxHash(txs[1])))
+}
+
+func TestTxPool_TxError(t *testing.T) {
+	defer monkey.UnpatchAll()
+	chain := newMockChain()
+	chain.setNonce(1)
+	assert := assert.New(t)
+	txpool := NewTxPool(DefaultTxPoolConfig, NewMockEvent())
+	txs := mock_samefrom_transactions(2)
+
+	err := txpool.AddTx(txs[0])
+	assert.True(errors.Is(err, ErrNonceTooLow))
+	var txErr *TxError
+	assert.True(errors.As(err, &txErr))
+	assert.Equal(common.TxHash(txs[0]), txErr.Hash)
+
+	assert.Nil(txpool.AddTx(txs[1]))
+	err = txpool.AddTx(txs[1])
+	assert.True(errors.Is(err, ErrAlreadyKnown))
+	assert.True(errors.As(err, &txErr))
+	assert.Equal(common.TxHash(txs[1]), txErr.Hash)
+}
+
+func TestTxPool_LocalTxs(t *testing.T) {
+	defer monkey.UnpatchAll()
+	newMockChain()
+	assert := assert.New(t)
+	txs := mock_transactions(3)
+	var mockTxPoolConfig = TxPoolConfig{
+		GlobalSlots:    2,
+		MaxTrsPerBlock: 10,
+		PriceLimit:     5,
+		Locals:         []types.Address{*txs[2].Data.From},
+	}
+	txpool := NewTxPool(mockTxPoolConfig, NewMockEvent())
+	pool := txpool.(*TxPool)
+
+	// remote tx is rejected by the price limit, while local one is accepted
+	assert.True(errors.Is(txpool.AddRemoteTx(txs[0]), ErrUnderpriced))
+	assert.Nil(txpool.AddLocalTx(txs[0]))
+	assert.True(pool.locals.Contains(*txs[0].Data.From))
+
+	// tx from the configured local account is local even if added as remote
+	assert.Nil(txpool.AddTx(txs[2]))
+	assert.Equal(2, pool.pending.Len())
+
+	// local txs can't be evicted by remote ones
+	remoteTx := mock_samefrom_transactions(5)[0]
+	remoteTx.Data.Price = big.NewInt(100)
+	assert.True(errors.Is(txpool.AddRemoteTx(remoteTx), ErrPoolFull))
+
+	// local tx is allowed to exceed the limit, if there is no remote tx to evict
+	assert.Nil(txpool.AddLocalTx(txs[1]))
+	assert.Equal(3, pool.pending.Len())
+}
+
+func TestTxPool_RemoveExpired(t *testing.T) {
+	defer monkey.UnpatchAll()
+	newMockChain()
+	assert := assert.New(t)
+	var mockTxPoolConfig = TxPoolConfig{
+		GlobalSlots:    10,
+		MaxTrsPerBlock: 10,
+		TxMaxCacheTime: 1,
+		ExpireInterval: 1,
+	}
+	txpool := NewTxPool(mockTxPoolConfig, NewMockEvent())
+	pool := txpool.(*TxPool)
+	sameFromTxs := mock_samefrom_transactions(5)
+	localTx := mock_transactions(1)[0]
+	assert.Nil(txpool.AddTx(sameFromTxs[0]))
+	assert.Nil(txpool.AddLocalTx(localTx))
+	age(pool, time.Hour, sameFromTxs[0], localTx)
+	assert.Nil(txpool.AddTx(sameFromTxs[1]))
+	ch := make(chan NewTxsEvent, 10)
+	sub := txpool.SubscribeNewTxs(ch)
+	defer sub.Unsubscribe()
+
+	// the expired remote tx is removed, its subsequent tx is moved to queue, local tx never expires
+	pool.mu.Lock()
+	pool.removeExpired()
+	pool.mu.Unlock()
+	pool.flushEvents()
+	assert.Equal(NewTxsEvent{Kind: TxsQueued, Txs: sameFromTxs[1:2]}, <-ch)
+	assert.Equal(NewTxsEvent{Kind: TxsExpired, Txs: sameFromTxs[:1]}, <-ch)
+	assert.Nil(txpool.GetTxByHash(common.TxHash(sameFromTxs[0])))
+	assert.NotNil(pool.queue.GetTx(common.TxHash(sameFromTxs[1])))
+	assert.NotNil(pool.pending.GetTx(common.TxHash(localTx)))
+	assert.Equal(TxStatusExpired, txpool.Status(common.TxHash(sameFromTxs[0])).Kind)
+
+	// the janitor removes the expired txs in background
+	age(pool, time.Hour, sameFromTxs[1])
+	assert.Nil(txpool.Start(context.Background()))
+	select {
+	case event := <-ch:
+		assert.Equal(NewTxsEvent{Kind: TxsExpired, Txs: sameFromTxs[1:2]}, event)
+	case <-time.After(10 * time.Second):
+		assert.Fail("expired txs are not removed")
+	}
+	assert.Equal(0, pool.queue.Len())
+	assert.Equal(1, pool.pending.Len())
+
+	txpool.Stop()
+	txpool.Stop()
+	assert.Nil(txpool.Close())
+
+	// moving txs between pending and queue doesn't restart their lifetime
+	mockTxPoolConfig.TxMaxCacheTime = 600
+	txpool = NewTxPool(mockTxPoolConfig, NewMockEvent())
+	pool = txpool.(*TxPool)
+	sameFromTxs = mock_samefrom_transactions(5)
+	assert.Nil(txpool.AddTx(sameFromTxs[1]))
+	age(pool, 2*time.Hour, sameFromTxs[1])
+	assert.Nil(txpool.AddTx(sameFromTxs[0]))
+	assert.NotNil(pool.pending.GetTx(common.TxHash(sameFromTxs[1])))
+	pool.mu.Lock()
+	pool.removeExpired()
+	pool.mu.Unlock()
+	assert.Equal(TxStatusExpired, txpool.Status(common.TxHash(sameFromTxs[1])).Kind)
+
+	assert.Nil(txpool.AddTx(sameFromTxs[1]))
+	age(pool, 2*time.Hour, sameFromTxs[0])
+	age(pool, 6*time.Minute, sameFromTxs[1])
+	pool.mu.Lock()
+	pool.removeExpired()
+	pool.mu.Unlock()
+	assert.NotNil(pool.queue.GetTx(common.TxHash(sameFromTxs[1])))
+	age(pool, 6*time.Minute, sameFromTxs[1])
+	pool.mu.Lock()
+	pool.removeExpired()
+	pool.mu.Unlock()
+	assert.Equal(TxStatusExpired, txpool.Status(common.TxHash(sameFromTxs[1])).Kind)
+}
+
+func TestTxPool_Lifecycle(t *testing.T) {
+	defer monkey.UnpatchAll()
+	newMockChain()
+	assert := assert.New(t)
+	events := NewMockEvent().(*MockEvent)
+	txpool := NewTxPool(DefaultTxPoolConfig, events)
+	assert.Equal(1, len(events.Subscribers[types.EventBlockCommitted]))
+	assert.Equal(1, len(events.Subscribers[types.EventBlockWritten]))
+	assert.Nil(txpool.Start(context.Background()))
+	assert.Nil(txpool.Start(context.Background()))
+	assert.Equal(1, len(events.Subscribers[types.EventBlockCommitted]))
+	txs := mock_transactions(2)
+	assert.Nil(txpool.AddTx(txs[0]))
+
+	// the events are unsubscribed, and the txs can't be added any more
+	txpool.Stop()
+	txpool.Stop()
+	assert.Equal(0, len(events.Subscribers[types.EventBlockCommitted]))
+	assert.Equal(0, len(events.Subscribers[types.EventBlockWritten]))
+	assert.True(errors.Is(txpool.AddTx(txs[1]), ErrPoolClosed))
+	assert.True(errors.Is(txpool.Start(context.Background()), ErrPoolClosed))
+	assert.Nil(txpool.Close())
+
+	// txpool is stopped once the context is done
+	txpool = NewTxPool(DefaultTxPoolConfig, events)
+	var unsubscribed types.Subscriber
+	for subscriber := range events.Subscribers[types.EventBlockCommitted] {
+		unsubscribed = subscriber
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	assert.Nil(txpool.Start(ctx))
+	cancel()
+	// mock event center closes the subscriber once it's unsubscribed
+	<-unsubscribed
+	assert.True(errors.Is(txpool.AddTx(txs[1]), ErrPoolClosed))
+	events.m.RLock()
+	assert.Equal(0, len(events.Subscribers[types.EventBlockCommitted]))
+	events.m.RUnlock()
+}
+
+func TestTxPool_WithoutStart(t *testing.T) {
+	defer monkey.UnpatchAll()
+	chain := newMockChain()
+	assert := assert.New(t)
+	events := NewMockEvent().(*MockEvent)
+	txpool := NewTxPool(DefaultTxPoolConfig, events)
+	pool := txpool.(*TxPool)
+	txs := mock_transactions(1)
+	assert.Nil(txpool.AddTx(txs[0]))
+
+	// txpool follows the chain without being started
+	head := pool.head
+	chain.setNonce(1)
+	for _, eventFunc := range events.Subscribers[types.EventBlockCommitted] {
+		eventFunc(nil)
+	}
+	assert.Equal(head+1, pool.head)
+	assert.Nil(txpool.GetTxByHash(common.TxHash(txs[0])))
+	txpool.Stop()
+	assert.Equal(0, len(events.Subscribers[types.EventBlockCommitted]))
+}
+
+func TestTxPool_Queries(t *testing.T) {
+	defer monkey.UnpatchAll()
+	newMockChain()
+	assert := assert.New(t)
+	var mockTxPoolConfig = TxPoolConfig{
+		GlobalSlots:    100,
+		GlobalQueue:    100,
+		AccountSlots:   1,
+		MaxTrsPerBlock: 10,
+	}
+	txpool := NewTxPool(mockTxPoolConfig, NewMockEvent())
+	sameFromTxs := mock_samefrom_transactions(5)
+	from := *sameFromTxs[0].Data.From
+	assert.Equal(uint64(0), txpool.Nonce(from))
+
+	// tx 1 exceeds the account slots, tx 3 has nonce gap, both are queued
+	assert.Nil(txpool.AddTx(sameFromTxs[0]))
+	assert.Nil(txpool.AddTx(sameFromTxs[1]))
+	assert.Nil(txpool.AddTx(sameFromTxs[3]))
+	otherTx := mock_transactions(1)[0]
+	assert.Nil(txpool.AddTx(otherTx))
+
+	assert.True(txpool.Has(common.TxHash(sameFromTxs[1])))
+	assert.False(txpool.Has(common.TxHash(sameFromTxs[2])))
+	assert.Equal(sameFromTxs[3], txpool.Get(common.TxHash(sameFromTxs[3])))
+	assert.Nil(txpool.Get(common.TxHash(sameFromTxs[2])))
+
+	pending, queued := txpool.Stats()
+	assert.Equal(2, pending)
+	assert.Equal(2, queued)
+	assert.Equal(map[types.Address][]*types.Transaction{
+		from:               {sameFromTxs[0]},
+		*otherTx.Data.From: {otherTx},
+	}, txpool.Pending())
+	assert.Equal(map[types.Address][]*types.Transaction{
+		from: {sameFromTxs[1], sameFromTxs[3]},
+	}, txpool.Queued())
+	pendingTxs, queuedTxs := txpool.ContentFrom(from)
+	assert.Equal([]*types.Transaction{sameFromTxs[0]}, pendingTxs)
+	assert.Equal([]*types.Transaction{sameFromTxs[1], sameFromTxs[3]}, queuedTxs)
+
+	// the next nonce stops at the gap, while the highest nonce in pool doesn't
+	assert.Equal(uint64(2), txpool.Nonce(from))
+	assert.Equal(uint64(3), txpool.GetPoolNonce(from))
+	assert.Nil(txpool.AddTx(sameFromTxs[2]))
+	assert.Equal(uint64(4), txpool.Nonce(from))
+	assert.Equal(uint64(1), txpool.Nonce(*otherTx.Data.From))
+}
+
+func TestTxPool_AddTxs(t *testing.T) {
+	defer monkey.UnpatchAll()
+	chain := newMockChain()
+	chain.setNonce(1)
+	assert := assert.New(t)
+	events := NewMockEvent()
+	notified := make(chan interface{}, 2)
+	events.Subscribe(types.EventAddTxToTxPool, func(v interface{}) {
+		notified <- v
+	})
+	txpool := NewTxPool(DefaultTxPoolConfig, events)
+	sameFromTxs := mock_samefrom_transactions(5)
+	negativeTx := mock_samefrom_transactions(5)[4]
+	negativeTx.Data.Amount = big.NewInt(-1)
+	errs := txpool.AddTxs([]*types.Transaction{
+		sameFromTxs[1], sameFromTxs[0], sameFromTxs[2], sameFromTxs[1], negativeTx, sameFromTxs[3],
+	})
+	assert.Equal(6, len(errs))
+	assert.Nil(errs[0])
+	assert.True(errors.Is(errs[1], ErrNonceTooLow))
+	assert.Nil(errs[2])
+	assert.True(errors.Is(errs[3], ErrAlreadyKnown))
+	assert.True(errors.Is(errs[4], ErrNegativeValue))
+	assert.Nil(errs[5])
+
+	// chain nonce is fetched once for the batch, and one event is notified for the accepted txs
+	assert.Equal(1, chain.nonceQueries())
+	pending, queued := txpool.Stats()
+	assert.Equal(3, pending)
+	assert.Equal(0, queued)
+	select {
+	case v := <-notified:
+		assert.Equal(TxsBatch{sameFromTxs[1], sameFromTxs[2], sameFromTxs[3]}, v)
+	case <-time.After(time.Second):
+		assert.Fail("no event notified")
+	}
+	select {
+	case <-notified:
+		assert.Fail("unexpected event notified")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestTxPool_ResetReorg(t *testing.T) {
+	defer monkey.UnpatchAll()
+	chain := newMockChain()
+	chain.setNonce(1)
+	mockBlock := func(height uint64, hash, prevHash string, txs ...*types.Transaction) *types.Block {
+		return &types.Block{
+			Header:       &types.Header{Height: height, PrevBlockHash: common.HexToHash(prevHash)},
+			HeaderHash:   common.HexToHash(hash),
+			Transactions: txs,
+		}
+	}
+	sameFromTxs := mock_samefrom_transactions(5)
+	genesis := mockBlock(0, "0x10", "0x0")
+	oldBlock1 := mockBlock(1, "0x11", "0x10", sameFromTxs[0])
+	oldBlock2 := mockBlock(2, "0x12", "0x11", sameFromTxs[1], sameFromTxs[2])
+	newBlock1 := mockBlock(1, "0x21", "0x10", sameFromTxs[0])
+	newBlock2 := mockBlock(2, "0x22", "0x21")
+	blocks := make(map[types.Hash]*types.Block)
+	for _, block := range []*types.Block{genesis, oldBlock1, oldBlock2, newBlock1, newBlock2} {
+		blocks[block.HeaderHash] = block
+	}
+	monkey.PatchInstanceMethod(reflect.TypeOf(chain.repo), "GetBlockByHash",
+		func(_ *repository.Repository, hash types.Hash) (*types.Block, error) {
+			if block, ok := blocks[hash]; ok {
+				return block, nil
+			}
+			return nil, errors.New("block not found")
+		})
+	assert := assert.New(t)
+	txpool := NewTxPool(DefaultTxPoolConfig, NewMockEvent())
+
+	// extending the chain reinjects nothing
+	txpool.Reset(newBlock1, newBlock2)
+	pending, queued := txpool.Stats()
+	assert.Equal(0, pending+queued)
+
+	// unknown ancestor, nothing is reinjected
+	txpool.Reset(mockBlock(3, "0x33", "0x32", sameFromTxs[3]), newBlock2)
+	pending, queued = txpool.Stats()
+	assert.Equal(0, pending+queued)
+
+	// missing heads or headers are treated as a plain reset
+	txpool.Reset(nil, newBlock2)
+	txpool.Reset(oldBlock2, nil)
+	txpool.Reset(&types.Block{HeaderHash: oldBlock2.HeaderHash}, newBlock2)
+	txpool.Reset(oldBlock2, &types.Block{HeaderHash: newBlock2.HeaderHash})
+	pending, queued = txpool.Stats()
+	assert.Equal(0, pending+queued)
+
+	// the txs of old chain not included in new chain are reinjected
+	txpool.Reset(oldBlock2, newBlock2)
+	assert.Equal([]*types.Transaction{sameFromTxs[1], sameFromTxs[2]}, txpool.Pending()[*sameFromTxs[0].Data.From])
+	assert.Equal(TxStatusUnknown, txpool.Status(common.TxHash(sameFromTxs[0])).Kind)
+}
+
+func TestTxPool_ConcurrentAdd(t *testing.T) {
+	defer monkey.UnpatchAll()
+	newMockChain()
+	assert := assert.New(t)
+	addConcurrently := func(txpool TxsPool, txs [][]*types.Transaction) {
+		var wg sync.WaitGroup
+		for _, accountTxs := range txs {
+			wg.Add(1)
+			go func(accountTxs []*types.Transaction) {
+				defer wg.Done()
+				for _, tx := range accountTxs {
+					txpool.AddTx(tx)
+				}
+			}(accountTxs)
+		}
+		wg.Wait()
+	}
+
+	// txs of different accounts are added in parallel, while block assembly reads consistent snapshots
+	txpool := NewTxPool(DefaultTxPoolConfig, NewMockEvent())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			nonces := make(map[types.Address]uint64)
+			for _, tx := range txpool.GetTxs() {
+				assert.Equal(nonces[*tx.Data.From], tx.Data.AccountNonce)
+				nonces[*tx.Data.From]++
+			}
+		}
+	}()
+	addConcurrently(txpool, mock_accounts_transactions(8, 20))
+	<-done
+	pending, queued := txpool.Stats()
+	assert.Equal(160, pending)
+	assert.Equal(0, queued)
+	assert.Equal(160, len(txpool.GetTxs()))
+
+	// the pool never exceeds the limit when the txs are added in parallel
+	config := DefaultTxPoolConfig
+	config.GlobalSlots = 50
+	config.AccountSlots = 50
+	txpool = NewTxPool(config, NewMockEvent())
+	addConcurrently(txpool, mock_accounts_transactions(8, 20))
+	pending, _ = txpool.Stats()
+	assert.True(pending <= 50)
+	assert.Equal(pending, len(txpool.GetTxs()))
+}
+
+func TestTxPool_ReorgLoop(t *testing.T) {
+	defer monkey.UnpatchAll()
+	chain := newMockChain()
+	assert := assert.New(t)
+	txpool := NewTxPool(DefaultTxPoolConfig, NewMockEvent())
+	pool := txpool.(*TxPool)
+	assert.Nil(txpool.Start(context.Background()))
+	defer txpool.Stop()
+	txs := mock_samefrom_transactions(4)
+
+	// queued txs are promoted by the reorg loop once the gap is filled
+	assert.Nil(txpool.AddTx(txs[1]))
+	assert.Nil(txpool.AddTx(txs[2]))
+	assert.Nil(txpool.AddTx(txs[0]))
+	<-pool.scheduleReorg(false)
+	pending, queued := txpool.Stats()
+	assert.Equal(3, pending)
+	assert.Equal(0, queued)
+
+	// block commit resets txpool in background
+	chain.commit(txs[:2])
+	pool.updateChainInstance(nil)
+	<-pool.scheduleReorg(false)
+	assert.Equal(TxStatusIncluded, txpool.Status(common.TxHash(txs[0])).Kind)
+	assert.Equal(TxStatusIncluded, txpool.Status(common.TxHash(txs[1])).Kind)
+	assert.Equal(TxStatusPending, txpool.Status(common.TxHash(txs[2])).Kind)
+
+	// the limit exceeded by the txs promoted on block commit is enforced by the reorg loop
+	config := DefaultTxPoolConfig
+	config.GlobalSlots = 2
+	events := NewMockEvent()
+	txpool = NewTxPool(config, events)
+	pool = txpool.(*TxPool)
+	assert.Nil(txpool.Start(context.Background()))
+	defer txpool.Stop()
+	accountsTxs := mock_accounts_transactions(2, 3)
+	cheap, pricy := accountsTxs[0], accountsTxs[1]
+	for _, tx := range []*types.Transaction{pricy[0], pricy[1], cheap[1], cheap[2]} {
+		assert.Nil(txpool.AddTx(tx))
+	}
+	pending, queued = txpool.Stats()
+	assert.Equal(2, pending)
+	assert.Equal(2, queued)
+	ch := make(chan NewTxsEvent, 10)
+	sub := txpool.SubscribeNewTxs(ch)
+	defer sub.Unsubscribe()
+	chain.commit(cheap[:1])
+	assert.Nil(events.Notify(types.EventBlockCommitted, nil))
+	select {
+	case <-ch:
+	case <-time.After(10 * time.Second):
+		assert.FailNow("txpool is not reset on block commit")
+	}
+	assert.Equal(2, pool.pending.Len())
+	assert.Equal(TxStatusPending, txpool.Status(common.TxHash(pricy[0])).Kind)
+	assert.Equal(TxStatusPending, txpool.Status(common.TxHash(pricy[1])).Kind)
+	assert.Equal(TxStatusEvicted, txpool.Status(common.TxHash(cheap[1])).Kind)
+}
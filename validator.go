@@ -0,0 +1,115 @@
+package txpool
+
+import (
+	"github.com/DSiSc/craft/types"
+	"github.com/DSiSc/repository"
+	"github.com/DSiSc/txpool/common"
+)
+
+const (
+	TxGas                 uint64 = 21000 // Per transaction not creating a contract.
+	TxGasContractCreation uint64 = 53000 // Per transaction that creates a contract.
+	TxDataZeroGas         uint64 = 4     // Per byte of data attached to a transaction that equals zero.
+	TxDataNonZeroGas      uint64 = 68    // Per byte of data attached to a transaction that is not equal to zero.
+)
+
+// TxValidator checks whether a transaction is acceptable by the tx pool.
+type TxValidator interface {
+	// Validate returns a typed error if tx is invalid on the specified chain state.
+	Validate(tx *types.Transaction, chain *repository.Repository) error
+}
+
+// TxValidatorFunc is an adapter to allow the use of ordinary functions as TxValidator.
+type TxValidatorFunc func(tx *types.Transaction, chain *repository.Repository) error
+
+// Validate calls f(tx, chain).
+func (f TxValidatorFunc) Validate(tx *types.Transaction, chain *repository.Repository) error {
+	return f(tx, chain)
+}
+
+// NewTxSizeValidator creates a validator rejecting the transactions whose RLP encoded size exceeds maxSize.
+func NewTxSizeValidator(maxSize uint64) TxValidator {
+	return TxValidatorFunc(func(tx *types.Transaction, chain *repository.Repository) error {
+		if common.TxSize(tx) > maxSize {
+			return ErrOversizedData
+		}
+		return nil
+	})
+}
+
+// NewPayloadSizeValidator creates a validator rejecting the transactions whose payload exceeds maxSize.
+func NewPayloadSizeValidator(maxSize uint64) TxValidator {
+	return TxValidatorFunc(func(tx *types.Transaction, chain *repository.Repository) error {
+		if uint64(len(tx.Data.Payload)) > maxSize {
+			return ErrOversizedPayload
+		}
+		return nil
+	})
+}
+
+// NewGasLimitValidator creates a validator rejecting the transactions whose gas limit exceeds the block gas limit.
+func NewGasLimitValidator(blockGasLimit uint64) TxValidator {
+	return TxValidatorFunc(func(tx *types.Transaction, chain *repository.Repository) error {
+		if tx.Data.GasLimit > blockGasLimit {
+			return ErrGasLimit
+		}
+		return nil
+	})
+}
+
+// NewAmountValidator creates a validator rejecting the transactions with negative amount.
+func NewAmountValidator() TxValidator {
+	return TxValidatorFunc(func(tx *types.Transaction, chain *repository.Repository) error {
+		if tx.Data.Amount != nil && tx.Data.Amount.Sign() < 0 {
+			return ErrNegativeValue
+		}
+		return nil
+	})
+}
+
+// NewIntrinsicGasValidator creates a validator rejecting the transactions whose gas limit can't cover the
+// intrinsic gas.
+func NewIntrinsicGasValidator() TxValidator {
+	return TxValidatorFunc(func(tx *types.Transaction, chain *repository.Repository) error {
+		gas, ok := IntrinsicGas(tx.Data.Payload, tx.Data.Recipient == nil)
+		if !ok || tx.Data.GasLimit < gas {
+			return ErrIntrinsicGas
+		}
+		return nil
+	})
+}
+
+// NewBalanceValidator creates a validator rejecting the transactions whose sender can't afford the cost on chain.
+func NewBalanceValidator() TxValidator {
+	return TxValidatorFunc(func(tx *types.Transaction, chain *repository.Repository) error {
+		if chain.GetBalance(*tx.Data.From).Cmp(common.TxCost(tx)) < 0 {
+			return ErrInsufficientFunds
+		}
+		return nil
+	})
+}
+
+// IntrinsicGas computes the intrinsic gas of a transaction with the given payload, false will be returned if the
+// gas overflows uint64.
+func IntrinsicGas(payload []byte, contractCreation bool) (uint64, bool) {
+	gas := TxGas
+	if contractCreation {
+		gas = TxGasContractCreation
+	}
+	var nz uint64
+	for _, b := range payload {
+		if b != 0 {
+			nz++
+		}
+	}
+	z := uint64(len(payload)) - nz
+	if nz > (^uint64(0)-gas)/TxDataNonZeroGas {
+		return 0, false
+	}
+	gas += nz * TxDataNonZeroGas
+	if z > (^uint64(0)-gas)/TxDataZeroGas {
+		return 0, false
+	}
+	gas += z * TxDataZeroGas
+	return gas, true
+}
@@ -0,0 +1,96 @@
+package txpool
+
+import (
+	"errors"
+	"github.com/DSiSc/monkey"
+	"github.com/DSiSc/txpool/common"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"testing"
+)
+
+func TestTxSizeValidator(t *testing.T) {
+	assert := assert.New(t)
+	tx := mock_transactions(1)[0]
+	assert.Nil(NewTxSizeValidator(common.TxSize(tx)).Validate(tx, nil))
+	assert.Equal(ErrOversizedData, NewTxSizeValidator(common.TxSize(tx)-1).Validate(tx, nil))
+}
+
+func TestPayloadSizeValidator(t *testing.T) {
+	assert := assert.New(t)
+	tx := mock_transactions(1)[0]
+	tx.Data.Payload = make([]byte, 10)
+	assert.Nil(NewPayloadSizeValidator(10).Validate(tx, nil))
+	assert.Equal(ErrOversizedPayload, NewPayloadSizeValidator(9).Validate(tx, nil))
+}
+
+func TestGasLimitValidator(t *testing.T) {
+	assert := assert.New(t)
+	tx := mock_transactions(1)[0]
+	tx.Data.GasLimit = 100
+	assert.Nil(NewGasLimitValidator(100).Validate(tx, nil))
+	assert.Equal(ErrGasLimit, NewGasLimitValidator(99).Validate(tx, nil))
+}
+
+func TestAmountValidator(t *testing.T) {
+	assert := assert.New(t)
+	tx := mock_transactions(1)[0]
+	assert.Nil(NewAmountValidator().Validate(tx, nil))
+	tx.Data.Amount = big.NewInt(-1)
+	assert.Equal(ErrNegativeValue, NewAmountValidator().Validate(tx, nil))
+}
+
+func TestIntrinsicGasValidator(t *testing.T) {
+	assert := assert.New(t)
+	tx := mock_transactions(1)[0]
+	tx.Data.Payload = []byte{0, 1}
+	tx.Data.GasLimit = TxGas + TxDataZeroGas + TxDataNonZeroGas
+	assert.Nil(NewIntrinsicGasValidator().Validate(tx, nil))
+	tx.Data.GasLimit--
+	assert.Equal(ErrIntrinsicGas, NewIntrinsicGasValidator().Validate(tx, nil))
+
+	// contract creation
+	tx.Data.Recipient = nil
+	tx.Data.GasLimit = TxGas + TxDataZeroGas + TxDataNonZeroGas
+	assert.Equal(ErrIntrinsicGas, NewIntrinsicGasValidator().Validate(tx, nil))
+}
+
+func TestBalanceValidator(t *testing.T) {
+	defer monkey.UnpatchAll()
+	assert := assert.New(t)
+	chain := newMockChain()
+	chain.setBalance(100)
+	tx := mock_transactions(1)[0]
+	tx.Data.Amount = big.NewInt(50)
+	tx.Data.Price = big.NewInt(5)
+	tx.Data.GasLimit = 10
+	assert.Nil(NewBalanceValidator().Validate(tx, chain.repo))
+	tx.Data.GasLimit = 11
+	assert.Equal(ErrInsufficientFunds, NewBalanceValidator().Validate(tx, chain.repo))
+}
+
+func TestTxPool_RegisterValidator(t *testing.T) {
+	defer monkey.UnpatchAll()
+	newMockChain()
+	assert := assert.New(t)
+	txpool := NewTxPool(DefaultTxPoolConfig, NewMockEvent())
+	txs := mock_transactions(2)
+	txs[0].Data.Amount = big.NewInt(-1)
+	assert.True(errors.Is(txpool.AddTx(txs[0]), ErrNegativeValue))
+
+	txpool.(*TxPool).RegisterValidator(NewGasLimitValidator(0))
+	assert.True(errors.Is(txpool.AddTx(txs[1]), ErrGasLimit))
+
+	// gas limit and intrinsic gas are validated if enabled by config
+	config := DefaultTxPoolConfig
+	config.BlockGasLimit = TxGas
+	config.IntrinsicGas = true
+	txpool = NewTxPool(config, NewMockEvent())
+	txs = mock_transactions(3)
+	txs[0].Data.GasLimit = TxGas + 1
+	assert.True(errors.Is(txpool.AddTx(txs[0]), ErrGasLimit))
+	txs[1].Data.GasLimit = TxGas - 1
+	assert.True(errors.Is(txpool.AddTx(txs[1]), ErrIntrinsicGas))
+	txs[2].Data.GasLimit = TxGas
+	assert.Nil(txpool.AddTx(txs[2]))
+}